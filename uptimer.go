@@ -2,18 +2,46 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"container/heap"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/tabwriter"
+	"text/template"
 	"time"
+	_ "time/tzdata"
+	"unicode/utf8"
 )
 
 const (
@@ -25,48 +53,465 @@ const (
 	maxBackoff    = 5 * time.Minute
 	backoffFactor = 2
 	certWarnDays  = 30
+	certRecheck   = time.Hour // for cert-change= endpoints
+	progressMin   = 100       // smallest batch of endpoints whose start is logged
+	maxBodyBytes  = 1 << 20
+	maxLineBytes  = 1 << 20
 )
 
 var (
-	wait_time      int
-	show_ok        bool
-	show_rt        bool
-	sound_alert    bool
-	no_window      bool
-	dashboard_port string
-	client         = &http.Client{Timeout: 30 * time.Second}
+	wait_time         time.Duration
+	fixed_interval    bool
+	show_ok           bool
+	show_rt           bool
+	sound_alert       bool
+	no_window         bool
+	dashboard_port    string
+	capture_headers   []string
+	run_once          bool
+	dial_timeout      time.Duration
+	tls_timeout       time.Duration
+	header_timeout    time.Duration
+	max_header_bytes  int64
+	max_redirects     int
+	redirect_policy   = "follow"
+	is_ready          atomic.Bool
+	max_idle_conns    int
+	location          = time.Local
+	daily_days        int
+	history_max       int
+	alert_template    *template.Template
+	ok_template       *template.Template
+	notifiers         []notifier
+	default_body      string
+	dashboard_theme   string
+	log_every         int
+	pool              *scheduler
+	degraded_percent  float64
+	degraded_window   int
+	degraded_alert    bool
+	summary_file      string
+	summary_retention time.Duration
+	previous_summary  map[string]pastEndpoint // from -summary-file, by ID; guarded by summaryMu
+	summaryMu         sync.Mutex
+	startup_grace     time.Duration
+	conn_stats        bool
+	mute_max          time.Duration
+	method_codes      = make(map[string]string)
+	metrics_log       *metricsLog
+	rt_precision      = time.Millisecond
+	cert_checks       chan struct{}
+	alert_digest      *digest
+	config_files      []string
+	config_loaded     time.Time // guarded by reloadMu
+	dashboard_title   string
+	dashboard_sub     string
+	notify_client     = &http.Client{Timeout: 10 * time.Second}
+	client            = &http.Client{Timeout: 30 * time.Second}
 
 	endpoints   = make(map[string]*EndpointStats)
 	endpointsMu sync.RWMutex
+	nextOrder   int
+	reloadMu    sync.Mutex
 	startTime   = time.Now()
+
+	// monitorCtx is cancelled on shutdown to stop the endpoint goroutines.
+	monitorCtx, stopMonitoring = context.WithCancel(context.Background())
 )
 
 type EndpointStats struct {
-	URL              string    `json:"url"`
-	ExpectedCode     string    `json:"expected_code"`
-	TotalChecks      int64     `json:"total_checks"`
-	SuccessfulChecks int64     `json:"successful_checks"`
-	ConsecFailures   int       `json:"consecutive_failures"`
-	LastCheck        time.Time `json:"last_check"`
-	LastStatus       string    `json:"last_status"`
-	LastResponseTime int64     `json:"last_response_time_ms"`
-	CertExpiry       time.Time `json:"cert_expiry,omitempty"`
-	IsUp             bool      `json:"is_up"`
+	URL              string            `json:"url"`
+	ID               string            `json:"id"`
+	Name             string            `json:"name,omitempty"`
+	Severity         string            `json:"severity"`
+	CodesFile        string            `json:"codes_file,omitempty"`
+	ExpectedCode     string            `json:"expected_code"`
+	TotalChecks      int64             `json:"total_checks"`
+	SuccessfulChecks int64             `json:"successful_checks"`
+	ConsecFailures   int               `json:"consecutive_failures"`
+	Incidents        int               `json:"incidents"`
+	LastIncident     time.Time         `json:"last_incident,omitzero"`
+	LongestOutage    string            `json:"longest_outage,omitempty"`
+	LastCheck        time.Time         `json:"last_check"`
+	LastSuccess      time.Time         `json:"last_success,omitzero"`
+	LastStatus       string            `json:"last_status"`
+	LastStatusText   string            `json:"last_status_text,omitempty"`
+	LastError        string            `json:"last_error,omitempty"`
+	LastResponseTime int64             `json:"last_response_time_ms"`
+	CertExpiry       time.Time         `json:"cert_expiry,omitempty"`
+	CertIssuer       string            `json:"cert_issuer,omitempty"`
+	CertFingerprint  string            `json:"cert_fingerprint,omitempty"`
+	IsUp             bool              `json:"is_up"`
+	State            string            `json:"state"`
+	IsDegraded       bool              `json:"is_degraded"`
+	Completed        bool              `json:"completed,omitempty"`
+	CapturedHeaders  map[string]string `json:"captured_headers,omitempty"`
+	LastTimeoutPhase string            `json:"last_timeout_phase,omitempty"`
+	FinalURL         string            `json:"final_url,omitempty"`
+	CertWarnings     []string          `json:"cert_warnings,omitempty"`
+	LastTimings      PhaseTimings      `json:"last_timings"`
+	ResolvedIP       string            `json:"resolved_ip,omitempty"`
+	ResolvedIPs      []string          `json:"resolved_ips,omitempty"`
+	SourceIP         string            `json:"source_ip,omitempty"`
+	BodyHash         string            `json:"body_hash,omitempty"`
+	ObservedInterval string            `json:"observed_interval,omitempty"`
+	Latency          *latencyStats     `json:"latency,omitempty"`
+	Connections      *connStats        `json:"connections,omitempty"`
+	Baseline         *baselineStats    `json:"baseline,omitempty"`
+	Capture          *captureStats     `json:"capture,omitempty"`
+	PortChecks       []portCheck       `json:"port_checks,omitempty"`
+	Daily            []*DailyStats     `json:"-"`
+	Config           EndpointConfig    `json:"config"`
+	client           *http.Client
+	recent           []bool
+	checkTimes       []time.Time
+	codesMod         time.Time
+	inOutage         bool
+	parts            []*EndpointStats // one per port of a ports= endpoint
+	part             bool
+	longestOutage    time.Duration
+	origin           string
+	order            int // guarded by endpointsMu
+	ctx              context.Context
+	stop             context.CancelFunc
+	responseTime     time.Duration
 	mu               sync.Mutex
 }
 
+// EndpointConfig holds how an endpoint is checked: the global settings in
+// effect when it was loaded plus the options given after the expected code
+// on its line.
+type EndpointConfig struct {
+	Method            string        `json:"method"`
+	Interval          time.Duration `json:"-"`
+	Timeout           time.Duration `json:"-"`
+	Budget            time.Duration `json:"-"`
+	Body              string        `json:"body,omitempty"`
+	DisableKeepAlives bool          `json:"disable_keepalives,omitempty"`
+	NoProxy           bool          `json:"no_proxy,omitempty"`
+	SourceIP          string        `json:"source_ip,omitempty"`
+	sourceIP          net.IP
+	Socket            string `json:"socket,omitempty"`
+	socketURL         string
+	Host              string `json:"host,omitempty"`
+	Location          string `json:"location,omitempty"`
+	HTTPSRedirect     string `json:"https_redirect,omitempty"`
+	defaultCode       bool
+	locationRe        *regexp.Regexp
+	SNI               string `json:"sni,omitempty"`
+	Cron              string `json:"cron,omitempty"`
+	cron              *cronSchedule
+	MaxChecks         int      `json:"max_checks,omitempty"`
+	Warmup            bool     `json:"warmup,omitempty"`
+	Ports             []string `json:"ports,omitempty"`
+	PortsRule         string   `json:"ports_rule,omitempty"`
+	Anomaly           float64  `json:"anomaly,omitempty"`
+	Capture           string   `json:"capture,omitempty"`
+	CaptureMin        *float64 `json:"capture_min,omitempty"`
+	CaptureMax        *float64 `json:"capture_max,omitempty"`
+	captureRe         *regexp.Regexp
+	IPChange          bool   `json:"ip_change,omitempty"`
+	CertChange        bool   `json:"cert_change,omitempty"`
+	CertErrors        string `json:"cert_errors,omitempty"`
+	SkipCertCheck     bool   `json:"skip_cert_check,omitempty"`
+	Hash              string `json:"hash,omitempty"`
+	Data              string `json:"data,omitempty"`
+	ContentType       string `json:"content_type,omitempty"`
+	Expect            string `json:"expect,omitempty"`
+	expectRe          *regexp.Regexp
+	Validate          string `json:"validate,omitempty"`
+	validate          []string
+	TokenURL          string         `json:"token_url,omitempty"`
+	ClientID          string         `json:"client_id,omitempty"`
+	ClientSecret      string         `json:"client_secret,omitempty"`
+	Scope             string         `json:"scope,omitempty"`
+	Cookies           []*http.Cookie `json:"-"`
+	AssertHeaders     []string       `json:"assert_headers,omitempty"`
+	headerAsserts     []headerAssert
+	bodyExpr          bodyExpr
+	oauth             *tokenSource
+}
+
+// MarshalJSON is the only way the config leaves the process (the API), so
+// secrets must be redacted here or kept out with a json:"-" tag.
+func (c EndpointConfig) MarshalJSON() ([]byte, error) {
+	if c.ClientSecret != "" {
+		c.ClientSecret = "[redacted]"
+	}
+	type plain EndpointConfig
+	return json.Marshal(struct {
+		plain
+		Interval string `json:"interval"`
+		Timeout  string `json:"timeout"`
+		Budget   string `json:"budget,omitempty"`
+	}{plain(c), c.Interval.String(), c.Timeout.String(), budgetString(c.Budget)})
+}
+
+type DailyStats struct {
+	Date             string `json:"date"`
+	TotalChecks      int64  `json:"total_checks"`
+	SuccessfulChecks int64  `json:"successful_checks"`
+}
+
 func main() {
 	showOkFlag := flag.Bool("so", false, "show ok answers")
 	showRtFlag := flag.Bool("rt", false, "show response time")
 	soundAlertFlag := flag.Bool("sa", false, "sound alert on failure")
 	dashboardFlag := flag.String("dp", "", "dashboard port (e.g., 8080)")
 	noWindowFlag := flag.Bool("nw", false, "no window (requires -dp)")
+	captureHeadersFlag := flag.String("ch", "", "comma-separated response headers to capture (e.g., X-Cache,Server,CF-Ray)")
+	onceFlag := flag.Bool("once", false, "check every endpoint once and exit (non-zero if any is down)")
+	intervalFlag := flag.Duration("interval", 0, "time between checks; every line of the config is then an endpoint (default: a number of seconds on the first line, or 10s)")
+	timeoutFlag := flag.Duration("timeout", 30*time.Second, "request timeout for endpoints without their own timeout= option")
+	dialTimeoutFlag := flag.Duration("dial-timeout", 30*time.Second, "timeout for DNS lookup and TCP connect")
+	tlsTimeoutFlag := flag.Duration("tls-timeout", 10*time.Second, "timeout for the TLS handshake")
+	headerTimeoutFlag := flag.Duration("header-timeout", 0, "timeout waiting for response headers after the request is sent (0 = no limit)")
+	maxHeaderBytesFlag := flag.Int64("max-header-bytes", 1<<20, "largest response headers accepted from an endpoint, in bytes")
+	var methodCodeFlag stringList
+	flag.Var(&methodCodeFlag, "method-code", "default expected code for a method when the line gives none, e.g. OPTIONS=204 (repeatable)")
+	redirectPolicyFlag := flag.String("redirect-policy", "follow", "3xx handling: follow, or don't follow and treat any 3xx as up, down or exact (must equal the expected code)")
+	maxRedirectsFlag := flag.Int("max-redirects", 10, "redirects to follow before reporting a redirect loop")
+	exitOnEmptyFlag := flag.Bool("exit-on-empty", false, "exit with code 1 if no endpoints were loaded")
+	stdinFlag := flag.Bool("stdin", false, "read the endpoint list from standard input instead of the config file")
+	var configFlag stringList
+	flag.Var(&configFlag, "config", "path to an endpoints file (repeatable, default endpoints.txt)")
+	tzFlag := flag.String("tz", "", "IANA timezone used for daily uptime buckets (default local time)")
+	dailyDaysFlag := flag.Int("daily-days", 30, "number of days of daily uptime kept per endpoint")
+	historyFlag := flag.Int("history", 1000, "maximum entries kept in any per-endpoint history buffer")
+	alertTemplateFlag := flag.String("alert-template", defaultAlertTemplate, "text/template for failure messages")
+	okTemplateFlag := flag.String("ok-template", defaultOkTemplate, "text/template for successful check messages")
+	connStatsFlag := flag.Bool("conn-stats", false, "count new and reused connections per endpoint in the API")
+	maxIdleFlag := flag.Int("max-idle-per-host", http.DefaultMaxIdleConnsPerHost, "idle keep-alive connections kept per host")
+	var webhookFlag stringList
+	flag.Var(&webhookFlag, "webhook", "URL to POST a JSON alert to when an endpoint goes down or recovers (repeatable)")
+	var customWebhookFlag stringList
+	flag.Var(&customWebhookFlag, "custom-webhook", "\"URL TEMPLATE_FILE\": POST the alert rendered with a text/template body file, for services needing their own JSON (repeatable)")
+	var criticalWebhookFlag stringList
+	flag.Var(&criticalWebhookFlag, "critical-webhook", "like -webhook, but only for endpoints with severity=critical, e.g. a paging integration (repeatable)")
+	testNotifyFlag := flag.Bool("test-notify", false, "send a test alert through every configured notifier and exit")
+	durationFlag := flag.Duration("duration", 0, "stop and print the summary after this long (e.g., 10m)")
+	bodyFlag := flag.String("body", "", "default body rule for endpoints without their own body= option")
+	themeFlag := flag.String("theme", "default", "dashboard theme: default or colorblind")
+	logEveryFlag := flag.Int("log-every", 0, "during an outage, log only the first failure and then every Nth one (0 = log all)")
+	schedulerFlag := flag.String("scheduler", "goroutine", "scheduling model: goroutine (one per endpoint) or pool (fixed workers, for thousands of endpoints)")
+	workersFlag := flag.Int("workers", 32, "number of workers for -scheduler pool")
+	degradedFlag := flag.Float64("degraded", 0, "mark endpoints DEGRADED when their recent success rate drops below this percent (0 = off)")
+	degradedWindowFlag := flag.Int("degraded-window", 20, "number of recent checks used for -degraded")
+	degradedAlertFlag := flag.Bool("degraded-alert", false, "notify when an endpoint becomes DEGRADED")
+	summaryFileFlag := flag.String("summary-file", "", "also write the shutdown summary as JSON to this file")
+	summaryRetentionFlag := flag.Duration("summary-retention", 30*24*time.Hour, "how long -summary-file keeps the totals of endpoints no longer listed (0 = drop them at once)")
+	heartbeatFlag := flag.Duration("heartbeat", 0, "log a one-line up/down summary at this interval (e.g., 1m)")
+	titleFlag := flag.String("title", "Uptimer Dashboard", "dashboard page title and heading")
+	subtitleFlag := flag.String("subtitle", "", "optional dashboard subtitle, e.g. the environment")
+	readyFileFlag := flag.String("ready-file", "", "write this file once every endpoint has been checked once (removed at startup)")
+	readyLineFlag := flag.Bool("ready-line", false, "print UPTIMER_READY to stdout once every endpoint has been checked once")
+	startupNotifyFlag := flag.Bool("startup-notify", false, "notify once when the first round of checks has completed")
+	digestFlag := flag.Duration("digest", 0, "collect alerts and send one summary per interval instead of one per event (e.g., 5m)")
+	certConcurrencyFlag := flag.Int("cert-concurrency", 8, "maximum number of SSL certificate checks running at once")
+	rtPrecisionFlag := flag.Duration("rt-precision", time.Millisecond, "rounding for displayed response times (e.g., 1us, 1ms, 100ms, 1s)")
+	metricsLogFlag := flag.String("metrics-log", "", "append one JSON line per check to this file")
+	muteMaxFlag := flag.Duration("mute-max", time.Hour, "how long /api/mute silences alerts at most before they come back on by themselves")
+	startupGraceFlag := flag.Duration("startup-grace", 0, "log but do not alert failures within this long after startup (e.g., 2m)")
+	launchRateFlag := flag.Int("launch-rate", 0, "endpoints started per second while loading (0 = all at once)")
+	versionFlag := flag.Bool("version", false, "print version information and exit")
+	probeFlag := flag.String("probe", "", "check one endpoint line (\"URL [CODE] [options]\") once and exit 0 if it passes, without reading the config")
 	flag.Parse()
+	if *versionFlag {
+		fmt.Println("uptimer", readBuildInfo())
+		return
+	}
 	show_ok = *showOkFlag
 	show_rt = *showRtFlag
 	sound_alert = *soundAlertFlag
 	dashboard_port = *dashboardFlag
 	no_window = *noWindowFlag
+	run_once = *onceFlag
+	if *timeoutFlag <= 0 {
+		color_print(Red, "Error: -timeout must be positive")
+		os.Exit(1)
+	}
+	client.Timeout = *timeoutFlag
+	if *intervalFlag < 0 {
+		color_print(Red, "Error: -interval cannot be negative")
+		os.Exit(1)
+	}
+	if *intervalFlag > 0 {
+		wait_time = *intervalFlag
+		fixed_interval = true
+	}
+	dial_timeout = *dialTimeoutFlag
+	tls_timeout = *tlsTimeoutFlag
+	header_timeout = *headerTimeoutFlag
+	if *maxHeaderBytesFlag <= 0 {
+		color_print(Red, "Error: -max-header-bytes must be positive")
+		os.Exit(1)
+	}
+	max_header_bytes = *maxHeaderBytesFlag
+	max_redirects = *maxRedirectsFlag
+	switch *redirectPolicyFlag {
+	case "follow", "up", "down", "exact":
+		redirect_policy = *redirectPolicyFlag
+	default:
+		color_printf(Red, "Error: unknown -redirect-policy %q (use follow, up, down or exact)\n", *redirectPolicyFlag)
+		os.Exit(1)
+	}
+	max_idle_conns = *maxIdleFlag
+	for _, value := range methodCodeFlag {
+		for _, pair := range strings.Split(value, ",") {
+			method, code, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			method = strings.ToUpper(method)
+			if !ok || method == "" || !codeRe.MatchString(code) {
+				color_printf(Red, "Error: invalid -method-code %q (use METHOD=CODE, e.g. OPTIONS=204)\n", pair)
+				os.Exit(1)
+			}
+			method_codes[method] = code
+		}
+	}
+	conn_stats = *connStatsFlag
+	if *startupGraceFlag < 0 {
+		color_print(Red, "Error: -startup-grace cannot be negative")
+		os.Exit(1)
+	}
+	startup_grace = *startupGraceFlag
+	if *muteMaxFlag <= 0 {
+		color_print(Red, "Error: -mute-max must be positive")
+		os.Exit(1)
+	}
+	mute_max = *muteMaxFlag
+	if *bodyFlag != "" {
+		if _, err := parseBodyExpr(*bodyFlag); err != nil {
+			color_printf(Red, "Error: invalid -body: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	default_body = *bodyFlag
+	if _, ok := themes[*themeFlag]; !ok {
+		color_printf(Red, "Error: unknown -theme %q (use default or colorblind)\n", *themeFlag)
+		os.Exit(1)
+	}
+	dashboard_theme = *themeFlag
+	dashboard_title = *titleFlag
+	dashboard_sub = *subtitleFlag
+	log_every = *logEveryFlag
+	degraded_percent = *degradedFlag
+	degraded_window = max(*degradedWindowFlag, 1)
+	degraded_alert = *degradedAlertFlag
+	summary_file = *summaryFileFlag
+	summary_retention = *summaryRetentionFlag
+	if summary_file != "" {
+		previous, err := readSummaryFile(summary_file)
+		if err != nil {
+			color_printf(Yellow, "Warning: cannot read the previous summary from %s, starting new totals: %v\n", summary_file, err)
+		}
+		previous_summary = previous
+	}
+	// A nanosecond between starts is as fast as a ticker goes.
+	if *launchRateFlag > int(time.Second) {
+		color_printf(Red, "Error: -launch-rate must be at most %d endpoints per second\n", int(time.Second))
+		os.Exit(1)
+	}
+	var launchTicker *time.Ticker
+	if *launchRateFlag > 0 && !run_once {
+		launchTicker = time.NewTicker(time.Second / time.Duration(*launchRateFlag))
+	}
+	switch *schedulerFlag {
+	case "goroutine":
+	case "pool":
+		if *workersFlag < 1 {
+			color_print(Red, "Error: -workers must be at least 1")
+			os.Exit(1)
+		}
+		if !run_once {
+			pool = newScheduler()
+			go pool.run(*workersFlag)
+		}
+	default:
+		color_printf(Red, "Error: unknown -scheduler %q (use goroutine or pool)\n", *schedulerFlag)
+		os.Exit(1)
+	}
+	daily_days = *dailyDaysFlag
+	if *historyFlag < 1 {
+		color_print(Red, "Error: -history must be at least 1")
+		os.Exit(1)
+	}
+	history_max = *historyFlag
+	if daily_days > history_max {
+		color_printf(Yellow, "Warning: -daily-days %d exceeds -history, keeping %d days\n", daily_days, history_max)
+		daily_days = history_max
+	}
+	if degraded_percent > 0 && degraded_window > history_max {
+		color_printf(Yellow, "Warning: -degraded-window %d exceeds -history, using %d checks\n", degraded_window, history_max)
+		degraded_window = history_max
+	}
+	var err error
+	if alert_template, err = parseMessageTemplate("alert", *alertTemplateFlag); err != nil {
+		color_printf(Red, "Error: invalid -alert-template: %v\n", err)
+		os.Exit(1)
+	}
+	if ok_template, err = parseMessageTemplate("ok", *okTemplateFlag); err != nil {
+		color_printf(Red, "Error: invalid -ok-template: %v\n", err)
+		os.Exit(1)
+	}
+	if *tzFlag != "" {
+		loc, err := time.LoadLocation(*tzFlag)
+		if err != nil {
+			color_printf(Red, "Error: unknown timezone %q: %v\n", *tzFlag, err)
+			os.Exit(1)
+		}
+		location = loc
+	}
+	if *rtPrecisionFlag <= 0 {
+		color_print(Red, "Error: -rt-precision must be positive")
+		os.Exit(1)
+	}
+	rt_precision = *rtPrecisionFlag
+	cert_checks = make(chan struct{}, max(*certConcurrencyFlag, 1))
+	if *metricsLogFlag != "" {
+		if metrics_log, err = openMetricsLog(*metricsLogFlag); err != nil {
+			color_printf(Red, "Error: cannot open -metrics-log: %v\n", err)
+			os.Exit(1)
+		}
+		defer metrics_log.close()
+		go metrics_log.flushEvery(5 * time.Second)
+	}
+	client.Transport = newTransport()
+	client.CheckRedirect = checkRedirect
+	for _, name := range strings.Split(*captureHeadersFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			capture_headers = append(capture_headers, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	for _, target := range webhookFlag {
+		notifiers = append(notifiers, &webhookNotifier{url: target})
+	}
+	for _, value := range customWebhookFlag {
+		target, path, ok := splitCustomWebhook(value)
+		if !ok {
+			color_printf(Red, "Error: invalid -custom-webhook %q (use \"URL TEMPLATE_FILE\")\n", value)
+			os.Exit(1)
+		}
+		body, err := parseWebhookTemplate(path)
+		if err != nil {
+			color_printf(Red, "Error: invalid -custom-webhook template: %v\n", err)
+			os.Exit(1)
+		}
+		notifiers = append(notifiers, &webhookNotifier{url: target, body: body})
+	}
+	for _, target := range criticalWebhookFlag {
+		notifiers = append(notifiers, &webhookNotifier{url: target, criticalOnly: true})
+	}
+	if *digestFlag > 0 && len(notifiers) > 0 && !run_once {
+		alert_digest = &digest{interval: *digestFlag, reportedCerts: make(map[string]time.Time)}
+		go alert_digest.run()
+	}
+
+	if *testNotifyFlag {
+		if !testNotifiers() {
+			os.Exit(1)
+		}
+		return
+	}
 
 	if no_window && dashboard_port == "" {
 		color_print(Red, "Error: -nw flag requires -dp flag to be set")
@@ -77,35 +522,80 @@ func main() {
 		hideConsoleWindow()
 	}
 
-	file, err := os.Open("endpoints.txt")
-	if err != nil {
-		_, err := os.Create("endpoints.txt")
-		if err != nil {
-			panic(err)
-		}
-		color_print(Green, "endpoints.txt file was created!\nFill out the file to use the program")
-		os.Exit(1)
+	if *probeFlag != "" {
+		os.Exit(probe(*probeFlag))
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-
-	if scanner.Scan() {
-		line := scanner.Text()
-		num, err := strconv.Atoi(line)
-		if err != nil {
-			color_print(Red, "Wait time not found. Set to default 10 seconds")
-			wait_time = 10
-			regex_to_handle(line)
+	color_printf(Green, "Uptimer %s\n", readBuildInfo())
+	if fixed_interval {
+		color_printf(Green, "Wait time is %v (-interval)\n", wait_time)
+	}
+	if *readyFileFlag != "" {
+		// A file left by an earlier run must not signal readiness.
+		if err := os.Remove(*readyFileFlag); err != nil && !os.IsNotExist(err) {
+			color_printf(Red, "Error: cannot remove -ready-file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(configFlag) == 0 {
+		configFlag = stringList{"endpoints.txt"}
+	}
+	source := strings.Join(configFlag, ", ")
+	loader := newLoader(true)
+	if *stdinFlag {
+		source = "standard input"
+		loader.loadEndpoints(os.Stdin, "stdin", true)
+	} else {
+		if _, err := os.Stat(configFlag[0]); len(configFlag) == 1 && os.IsNotExist(err) {
+			_, err := os.Create(configFlag[0])
+			if err != nil {
+				panic(err)
+			}
+			color_printf(Green, "%s file was created!\nFill out the file to use the program\n", configFlag[0])
+			os.Exit(1)
+		}
+		for i, path := range configFlag {
+			if err := loader.loadFile(path, i == 0); err != nil {
+				color_printf(Red, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		config_files = configFlag
+	}
+	config_loaded = time.Now()
+	if !run_once {
+		if launchTicker != nil {
+			// A staggered launch can take a while: the dashboard, reloads
+			// and Ctrl+C are served meanwhile.
+			go func() {
+				startInOrder(loader.list, launchTicker)
+				launchTicker.Stop()
+			}()
 		} else {
-			color_printf(Green, "Wait time is %d seconds\n", num)
-			wait_time = num
+			startInOrder(loader.list, nil)
 		}
 	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		regex_to_handle(line)
+	endpointsMu.RLock()
+	loaded := len(endpoints)
+	endpointsMu.RUnlock()
+	if loaded == 0 {
+		color_printf(Yellow, "Warning: no endpoints were loaded from %s - check that each line is `URL [STATUS_CODE]`\n", source)
+		if *exitOnEmptyFlag {
+			os.Exit(1)
+		}
+	} else {
+		color_printf(Green, "Loaded %d endpoints from %s\n", loaded, source)
+	}
+
+	if run_once {
+		allUp := runOnce()
+		printShutdownSummary()
+		if !allUp {
+			metrics_log.close()
+			os.Exit(1)
+		}
+		return
 	}
 
 	if dashboard_port != "" {
@@ -115,138 +605,3475 @@ func main() {
 
 	log_print(Green, "Listening...")
 
+	go announceReady(*readyFileFlag, *readyLineFlag, *startupNotifyFlag && len(notifiers) > 0)
+	if *heartbeatFlag > 0 {
+		go heartbeat(*heartbeatFlag)
+	}
+	if startup_grace > 0 {
+		log_printf(Green, "Alerts are held until %s (startup grace)\n", startTime.Add(startup_grace).Format("15:04:05"))
+	}
+	go refreshCodeFiles()
+
+	var deadline <-chan time.Time
+	if *durationFlag > 0 {
+		deadline = time.After(*durationFlag)
+	}
+
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+wait:
+	for {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				reloadEndpoints()
+				continue
+			}
+			break wait
+		case <-deadline:
+			log_printf(Green, "Run duration of %v reached, stopping\n", *durationFlag)
+			break wait
+		}
+	}
 
+	stopMonitoring()
+	if alert_digest != nil {
+		alert_digest.flush()
+	}
 	printShutdownSummary()
 }
 
-func regex_to_handle(line string) {
-	re := regexp.MustCompile(`^(https?://[a-zA-Z0-9._-]+(:\d+)?(?:/[^\s]*)?)\s*(\d{3})?$`)
-	if line == "" {
-		return
+// endpointLoader reads endpoint files. At startup (start set) each
+// endpoint is registered as soon as its line is parsed and started once all
+// files are read; for a reload the endpoints are only collected and applied
+// afterwards.
+type endpointLoader struct {
+	start    bool
+	included map[string]bool
+	byID     map[string]*EndpointStats
+	list     []*EndpointStats
+}
+
+func newLoader(start bool) *endpointLoader {
+	return &endpointLoader{start: start, included: make(map[string]bool), byID: make(map[string]*EndpointStats)}
+}
+
+// loadFile loads an endpoints file. A file is read only once, which also
+// ends include cycles.
+func (l *endpointLoader) loadFile(path string, first bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
 	}
-	m := re.FindStringSubmatch(line)
-	if m != nil {
-		url := m[1]
-		code := m[3]
-		if code == "" {
-			code = "200"
+	if l.included[abs] {
+		color_printf(Yellow, "Warning: %s is included more than once; skipping\n", path)
+		return nil
+	}
+	l.included[abs] = true
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	l.loadEndpoints(file, path, first)
+	return nil
+}
+
+// loadEndpoints reads an endpoint list in the endpoints.txt format: an
+// optional wait time on the first line followed by one endpoint per line.
+// Only the first file may set the wait time. A line "include PATH" loads
+// another file, relative to this one, in its place.
+func (l *endpointLoader) loadEndpoints(r io.Reader, name string, first bool) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		origin := fmt.Sprintf("%s:%d", name, lineNo)
+		// With -interval, the first line is an endpoint like any other.
+		if lineNo == 1 && !fixed_interval {
+			if num, err := strconv.Atoi(strings.TrimSpace(line)); err == nil {
+				if first {
+					color_printf(Green, "Wait time is %d seconds\n", num)
+					wait_time = time.Duration(num) * time.Second
+				} else {
+					color_printf(Yellow, "Warning: %s: wait time is only read from the first file; ignored\n", origin)
+				}
+				continue
+			}
+			if first {
+				color_print(Red, "Wait time not found. Set to default 10 seconds")
+				wait_time = 10 * time.Second
+			}
 		}
-		stats := &EndpointStats{
-			URL:          url,
-			ExpectedCode: code,
-			IsUp:         true,
+		if target, ok := strings.CutPrefix(strings.TrimSpace(line), "include "); ok && name != "stdin" {
+			target = strings.TrimSpace(target)
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(name), target)
+			}
+			if err := l.loadFile(target, false); err != nil {
+				log_printf(Red, "%s: include failed: %v\n", origin, err)
+			}
+			continue
+		}
+		for _, line := range expandLine(line, origin) {
+			if stats := regex_to_handle(line, origin); stats != nil {
+				l.add(stats)
+			}
 		}
-		endpointsMu.Lock()
-		endpoints[url] = stats
-		endpointsMu.Unlock()
+	}
 
-		go handle_endpoint(stats)
-	} else {
-		log_printf(Red, "%s line is incorrect!\n", line)
+	if err := scanner.Err(); err == bufio.ErrTooLong {
+		color_printf(Red, "Error reading endpoints: %s:%d is longer than %d bytes; it and any lines after it were not loaded\n", name, lineNo+1, maxLineBytes)
+	} else if err != nil {
+		color_printf(Red, "Error reading endpoints: %s: %v\n", name, err)
 	}
 }
 
-func handle_endpoint(stats *EndpointStats) {
-	currentBackoff := time.Duration(wait_time) * time.Second
-	normalInterval := currentBackoff
-	link := stats.URL
-	awaited_answer := stats.ExpectedCode
-
-	if len(link) > 5 && link[:5] == "https" {
-		checkSSLCert(link, stats)
+// expandLine turns a line whose URL contains {a,b} lists or {1..5} ranges into
+// one line per combination. Only the URL is expanded; the options are the
+// same for every resulting endpoint.
+func expandLine(line, origin string) []string {
+	trimmed := strings.TrimSpace(line)
+	url, rest := trimmed, ""
+	if i := strings.IndexAny(trimmed, " \t"); i >= 0 {
+		url, rest = trimmed[:i], trimmed[i:]
+	}
+	if !strings.Contains(url, "{") {
+		return []string{line}
+	}
+	urls, err := expandBraces(url)
+	if err != nil {
+		log_printf(Red, "%s: %s line is incorrect: %v\n", origin, line, err)
+		return nil
+	}
+	lines := make([]string, len(urls))
+	for i, url := range urls {
+		lines[i] = url + rest
 	}
+	return lines
+}
 
-	for {
-		start := time.Now()
-		resp, err := client.Get(link)
-		responseTime := time.Since(start)
+func (l *endpointLoader) add(stats *EndpointStats) {
+	if existing, exists := l.byID[stats.ID]; exists {
+		log_printf(Yellow, "%s: %s is already listed at %s; keeping the first (use method= or id= to monitor it twice)\n", stats.origin, stats.ID, existing.origin)
+		return
+	}
+	l.byID[stats.ID] = stats
+	l.list = append(l.list, stats)
+	if l.start {
+		register(stats)
+	}
+}
 
-		stats.mu.Lock()
-		stats.TotalChecks++
-		stats.LastCheck = time.Now()
-		stats.LastResponseTime = responseTime.Milliseconds()
+// startInOrder starts monitoring the given endpoints, critical ones first,
+// so that with -launch-rate or a large reload what matters most is checked
+// first. The list order is kept within each severity. With a ticker, each
+// start waits for its tick, and a shutdown ends the launch. Progress is
+// logged every 10% for lists of progressMin endpoints or more.
+func startInOrder(list []*EndpointStats, ticker *time.Ticker) {
+	list = slices.Clone(list)
+	slices.SortStableFunc(list, func(a, b *EndpointStats) int {
+		return severityRank[a.Severity] - severityRank[b.Severity]
+	})
+	start := time.Now()
+	step := len(list) / 10
+	for i, stats := range list {
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-monitorCtx.Done():
+				return
+			}
+		}
+		// Removed by a reload before its turn came.
+		if stats.ctx.Err() != nil {
+			continue
+		}
+		startMonitoring(stats)
+		if len(list) >= progressMin && ((i+1)%step == 0 || i+1 == len(list)) {
+			log_printf(Green, "Started %d/%d endpoints (%v)\n", i+1, len(list), time.Since(start).Round(time.Second))
+		}
+	}
+}
 
-		if err != nil {
-			stats.ConsecFailures++
-			stats.IsUp = false
-			stats.LastStatus = "ERROR"
-			stats.mu.Unlock()
+// register adds a loaded endpoint to the monitored set.
+func register(stats *EndpointStats) {
+	stats.ctx, stats.stop = context.WithCancel(monitorCtx)
+	endpointsMu.Lock()
+	stats.order = nextOrder
+	nextOrder++
+	endpoints[stats.ID] = stats
+	endpointsMu.Unlock()
+}
 
-			playAlert()
-			log_printf(Red, "%s - ERROR: %v (failures: %d, retry in %v)\n", link, err, stats.ConsecFailures, currentBackoff)
-			time.Sleep(currentBackoff)
-			currentBackoff = increaseBackoff(currentBackoff)
-			continue
+// reloadEndpoints re-reads the config files and applies the differences.
+// Endpoints still listed (matched by ID) keep their statistics and get the
+// new settings in place, removed ones stop and new ones start.
+func reloadEndpoints() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if len(config_files) == 0 {
+		log_print(Yellow, "Reload is not possible when reading endpoints from standard input")
+		return
+	}
+	log_printf(Green, "Reloading %s\n", strings.Join(config_files, ", "))
+	l := newLoader(false)
+	for i, path := range config_files {
+		if err := l.loadFile(path, i == 0); err != nil {
+			log_printf(Red, "Reload failed, keeping the current endpoints: %v\n", err)
+			return
 		}
-		resp.Body.Close()
+	}
+	if len(l.list) == 0 {
+		log_print(Red, "Reload found no endpoints, keeping the current ones")
+		return
+	}
 
-		rtSuffix := ""
-		if show_rt {
-			rtSuffix = fmt.Sprintf(" [%v]", responseTime.Round(time.Millisecond))
+	var added, restarted []*EndpointStats
+	updated, removed := 0, 0
+	endpointsMu.Lock()
+	for id, old := range endpoints {
+		if _, ok := l.byID[id]; !ok {
+			if summary_file != "" {
+				old.mu.Lock()
+				retainRemoved(old)
+				old.mu.Unlock()
+			}
+			old.stop()
+			delete(endpoints, id)
+			removed++
+		}
+	}
+	for i, stats := range l.list {
+		old, ok := endpoints[stats.ID]
+		if !ok {
+			stats.ctx, stats.stop = context.WithCancel(monitorCtx)
+			endpoints[stats.ID] = stats
+			added = append(added, stats)
+			old = stats
+		} else {
+			old.mu.Lock()
+			old.Name = stats.Name
+			old.Severity = stats.Severity
+			old.ExpectedCode = stats.ExpectedCode
+			old.CodesFile = stats.CodesFile
+			old.codesMod = stats.codesMod
+			// Changed credentials need a new token; the same ones keep it.
+			if old.Config.oauth != nil && stats.Config.oauth != nil && old.Config.oauth.sameCredentials(stats.Config.oauth) {
+				stats.Config.oauth = old.Config.oauth
+				for _, part := range stats.parts {
+					part.Config.oauth = old.Config.oauth
+				}
+			}
+			old.Config = stats.Config
+			// A check still running keeps using the old client; only its
+			// idle connections can go.
+			if old.client != stats.client && old.client.Transport != client.Transport {
+				old.client.CloseIdleConnections()
+			}
+			old.client = stats.client
+			old.parts = stats.parts
+			old.origin = stats.origin
+			// A raised or removed max-checks= resumes a completed endpoint.
+			if old.Completed && (old.Config.MaxChecks == 0 || old.TotalChecks < int64(old.Config.MaxChecks)) {
+				old.Completed = false
+				old.ctx, old.stop = context.WithCancel(monitorCtx)
+				restarted = append(restarted, old)
+			}
+			old.mu.Unlock()
+			updated++
 		}
+		old.order = i
+	}
+	nextOrder = len(l.list)
+	endpointsMu.Unlock()
 
-		answer := strconv.Itoa(resp.StatusCode)
-		stats.LastStatus = answer
+	config_loaded = time.Now()
+	startInOrder(append(added, restarted...), nil)
+	log_printf(Green, "Reload complete: %d added, %d removed, %d kept\n", len(added), removed, updated)
+}
 
-		if answer != awaited_answer {
-			stats.ConsecFailures++
-			stats.IsUp = false
-			stats.mu.Unlock()
+// codePattern is an expected status code: NNN, any, or a negation such as
+// !503 or not:5xx.
+const codePattern = `\d{3}|any|(?:!|not:)\d[\dxX]{2}`
 
-			playAlert()
-			log_printf(Red, "%s HAS RETURNED %s INSTEAD OF %s - POSSIBLE DOWN!!%s (failures: %d, retry in %v)\n",
-				link, answer, awaited_answer, rtSuffix, stats.ConsecFailures, currentBackoff)
-			time.Sleep(currentBackoff)
-			currentBackoff = increaseBackoff(currentBackoff)
-		} else {
-			stats.SuccessfulChecks++
-			stats.ConsecFailures = 0
-			stats.IsUp = true
-			stats.mu.Unlock()
+var codeRe = regexp.MustCompile(`^(?:` + codePattern + `)$`)
 
-			if show_ok {
-				log_printf(Green, "%s - %s AS EXPECTED%s\n", link, answer, rtSuffix)
+// regex_to_handle parses an endpoint line, logging and returning nil if it
+// is incorrect.
+func regex_to_handle(line, origin string) *EndpointStats {
+	re := regexp.MustCompile(`^(https?://[\p{L}\p{M}\p{N}._-]+(:\d+)?(?:/[^\s]*)?|unix://\S+)(?:\s+(` + codePattern + `))?((?:\s+\S.*)?)\s*$`)
+	if line == "" {
+		return nil
+	}
+	original := line
+	// An optional trailing JSON object carries the same options as the
+	// key=value form, e.g. https://example.com {"code":"204","method":"HEAD"}.
+	var inline string
+	if i := strings.IndexAny(line, " \t"); i >= 0 && strings.HasSuffix(strings.TrimSpace(line), "}") {
+		if j := strings.Index(line[i:], "{"); j >= 0 {
+			line, inline = line[:i+j], line[i+j:]
+		}
+	}
+	m := re.FindStringSubmatch(line)
+	if m != nil {
+		url := m[1]
+		stats := &EndpointStats{
+			URL:          url,
+			ExpectedCode: m[3],
+			Severity:     severityWarning,
+			State:        statePending,
+			origin:       origin,
+			Config: EndpointConfig{
+				Method:   http.MethodGet,
+				Interval: wait_time,
+				Timeout:  client.Timeout,
+			},
+		}
+		if default_body != "" {
+			applyOption(stats, "body", default_body)
+		}
+		err := parseOptions(m[4], stats)
+		if err == nil && inline != "" {
+			err = parseJSONOptions(inline, stats)
+		}
+		if err == nil {
+			err = finishOptions(&stats.Config)
+		}
+		if err == nil && strings.HasPrefix(url, "unix://") {
+			err = setUnixSocket(&stats.Config, url)
+		}
+		if err != nil {
+			log_printf(Red, "%s: %s line is incorrect: %v\n", origin, original, err)
+			return nil
+		}
+		if stats.ID == "" {
+			stats.ID = endpointID(url, stats.Config.Method)
+		}
+		if stats.ExpectedCode == "" {
+			stats.Config.defaultCode = true
+			// With location= the Location header is what must match.
+			stats.ExpectedCode = cmp.Or(method_codes[stats.Config.Method], "200")
+			if stats.Config.Location != "" {
+				stats.ExpectedCode = "any"
 			}
-			currentBackoff = normalInterval
-			time.Sleep(normalInterval)
 		}
+		stats.client = clientFor(stats.Config)
+		stats.parts = portParts(stats)
+		return stats
 	}
+	log_printf(Red, "%s: %s line is incorrect!\n", origin, line)
+	return nil
 }
 
-func checkSSLCert(link string, stats *EndpointStats) {
-	host := link[8:]
-	for i, c := range host {
-		if c == '/' || c == ':' {
-			host = host[:i]
-			break
-		}
+// setUnixSocket points an endpoint at a Unix domain socket, given as
+// unix:///var/run/app.sock:/health (the path defaults to /).
+func setUnixSocket(cfg *EndpointConfig, link string) error {
+	socket, path := strings.TrimPrefix(link, "unix://"), "/"
+	// The colon of a drive letter, as in unix://C:/run/app.sock, is part
+	// of the socket path.
+	if i := strings.LastIndex(socket, ":/"); i > 1 {
+		socket, path = socket[:i], socket[i+1:]
 	}
+	if len(cfg.Ports) > 0 {
+		return errors.New("ports cannot be used with a unix socket")
+	}
+	if cfg.sourceIP != nil {
+		return errors.New("source-ip cannot be used with a unix socket")
+	}
+	// The client dials the socket whatever the host; the URL gives the
+	// request its path and Host header.
+	cfg.Socket, cfg.socketURL = socket, "http://localhost"+path
+	return nil
+}
+
+// endpointID is the key an endpoint is known by when no id= option is
+// given: the URL, prefixed with the method unless it is GET.
+func endpointID(url, method string) string {
+	if method == http.MethodGet {
+		return url
+	}
+	return method + " " + url
+}
+
+// maxExpansions limits how many endpoints one line may expand into.
+const maxExpansions = 10000
+
+// portItemRe matches one ports= item: a port, optionally with the path
+// to check on it.
+var portItemRe = regexp.MustCompile(`^(\d+)(/\S*)?$`)
 
-	conn, err := tls.Dial("tcp", host+":443", &tls.Config{})
+// portParts builds the sub-checks of a ports= endpoint: its own URL and
+// the same host on each listed port, all with the endpoint's options. It
+// returns nil for an ordinary endpoint.
+func portParts(stats *EndpointStats) []*EndpointStats {
+	if len(stats.Config.Ports) == 0 {
+		return nil
+	}
+	base, err := url.Parse(stats.URL)
 	if err != nil {
-		log_printf(Yellow, "%s - SSL cert check failed: %v\n", link, err)
-		return
+		return nil
 	}
-	defer conn.Close()
+	cfg := stats.Config
+	cfg.Ports, cfg.PortsRule = nil, ""
+	newPart := func(label string, u *url.URL) *EndpointStats {
+		return &EndpointStats{
+			URL:          u.String(),
+			ID:           u.String(),
+			Name:         label,
+			ExpectedCode: stats.ExpectedCode,
+			Severity:     stats.Severity,
+			State:        statePending,
+			Config:       cfg,
+			client:       stats.client,
+			origin:       stats.origin,
+			part:         true,
+		}
+	}
+	own := base.Port()
+	if own == "" {
+		own = map[string]string{"http": "80", "https": "443"}[base.Scheme]
+	}
+	parts := []*EndpointStats{newPart(":"+own, base)}
+	for _, item := range stats.Config.Ports {
+		m := portItemRe.FindStringSubmatch(item)
+		u := *base
+		u.Host = net.JoinHostPort(base.Hostname(), m[1])
+		if m[2] != "" {
+			path, query, _ := strings.Cut(m[2], "?")
+			u.Path, u.RawPath, u.RawQuery = path, "", query
+		}
+		parts = append(parts, newPart(":"+item, &u))
+	}
+	return parts
+}
 
-	certs := conn.ConnectionState().PeerCertificates
-	if len(certs) > 0 {
-		expiry := certs[0].NotAfter
-		stats.mu.Lock()
-		stats.CertExpiry = expiry
-		stats.mu.Unlock()
+// expandBraces expands shell-style lists and ranges in an endpoint URL:
+// node-{a,b} is node-a and node-b, node-{1..3} is node-1 to node-3 and
+// {01..10} keeps the zero padding. Braces matching neither form are kept
+// as they are.
+func expandBraces(text string) ([]string, error) {
+	for from := 0; ; {
+		end := strings.IndexByte(text[from:], '}')
+		if end < 0 {
+			return []string{text}, nil
+		}
+		end += from
+		start := strings.LastIndexByte(text[:end], '{')
+		if start < from {
+			from = end + 1
+			continue
+		}
+		items, err := braceItems(text[start+1 : end])
+		if err != nil {
+			return nil, err
+		}
+		if items == nil {
+			from = end + 1
+			continue
+		}
+		var out []string
+		for _, item := range items {
+			expanded, err := expandBraces(text[:start] + item + text[end+1:])
+			if err != nil {
+				return nil, err
+			}
+			if out = append(out, expanded...); len(out) > maxExpansions {
+				return nil, fmt.Errorf("expands to more than %d endpoints", maxExpansions)
+			}
+		}
+		return out, nil
+	}
+}
 
-		daysUntilExpiry := int(time.Until(expiry).Hours() / 24)
-		if daysUntilExpiry <= certWarnDays {
-			playAlert()
-			log_printf(Yellow, "%s - SSL cert expires in %d days (%s)\n", link, daysUntilExpiry, expiry.Format("2006-01-02"))
-		} else if show_ok {
-			log_printf(Green, "%s - SSL cert valid for %d days\n", link, daysUntilExpiry)
+// braceItems returns what one {...} group stands for, or nil if it is not
+// a list or range.
+func braceItems(body string) ([]string, error) {
+	lo, hi, isRange := strings.Cut(body, "..")
+	if !isRange {
+		if !strings.Contains(body, ",") {
+			return nil, nil
+		}
+		return strings.Split(body, ","), nil
+	}
+	if len(lo) == 1 && len(hi) == 1 && isLetter(lo[0]) && isLetter(hi[0]) {
+		var items []string
+		for c, step := lo[0], rangeStep(int(lo[0]), int(hi[0])); ; c = byte(int(c) + step) {
+			items = append(items, string(c))
+			if c == hi[0] {
+				return items, nil
+			}
 		}
 	}
+	first, err1 := strconv.Atoi(lo)
+	last, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil {
+		return nil, nil
+	}
+	if count := max(first, last) - min(first, last) + 1; count > maxExpansions {
+		return nil, fmt.Errorf("range {%s} has more than %d values", body, maxExpansions)
+	}
+	width := 0
+	if (len(lo) > 1 && strings.TrimPrefix(lo, "-")[0] == '0') || (len(hi) > 1 && strings.TrimPrefix(hi, "-")[0] == '0') {
+		width = max(len(lo), len(hi))
+	}
+	var items []string
+	for n, step := first, rangeStep(first, last); ; n += step {
+		items = append(items, fmt.Sprintf("%0*d", width, n))
+		if n == last {
+			return items, nil
+		}
+	}
+}
+
+func rangeStep(from, to int) int {
+	if from > to {
+		return -1
+	}
+	return 1
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+var optionRe = regexp.MustCompile(`([a-z][a-z-]*)=("(?:[^"\\]|\\.)*"|\S*)`)
+
+// parseOptions applies the key=value options that may follow the expected
+// code on an endpoint line. Values containing spaces must be double-quoted.
+func parseOptions(text string, stats *EndpointStats) error {
+	pos := 0
+	for _, loc := range optionRe.FindAllStringSubmatchIndex(text, -1) {
+		if strings.TrimSpace(text[pos:loc[0]]) != "" {
+			return fmt.Errorf("unexpected %q", strings.TrimSpace(text[pos:loc[0]]))
+		}
+		pos = loc[1]
+
+		key, value := text[loc[2]:loc[3]], text[loc[4]:loc[5]]
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return fmt.Errorf("option %s: bad quoting", key)
+			}
+			value = unquoted
+		}
+		if err := applyOption(stats, key, value); err != nil {
+			return fmt.Errorf("option %s: %v", key, err)
+		}
+	}
+	if rest := strings.TrimSpace(text[pos:]); rest != "" {
+		return fmt.Errorf("unexpected %q", rest)
+	}
+	return nil
+}
+
+// parseJSONOptions applies an inline JSON object of options. Keys are the
+// option names; strings, numbers and booleans are accepted as values.
+func parseJSONOptions(text string, stats *EndpointStats) error {
+	var options map[string]any
+	if err := json.Unmarshal([]byte(text), &options); err != nil {
+		return fmt.Errorf("inline options: %v", err)
+	}
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		var value string
+		switch v := options[key].(type) {
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			return fmt.Errorf("option %s: expected a string, number or boolean", key)
+		}
+		if err := applyOption(stats, key, value); err != nil {
+			return fmt.Errorf("option %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// finishOptions checks and completes the options once all of them have
+// been applied.
+func finishOptions(cfg *EndpointConfig) error {
+	if cfg.TokenURL != "" || cfg.ClientID != "" || cfg.ClientSecret != "" {
+		if cfg.TokenURL == "" || cfg.ClientID == "" {
+			return errors.New("token-url and client-id are both required for OAuth")
+		}
+		cfg.oauth = &tokenSource{tokenURL: cfg.TokenURL, clientID: cfg.ClientID, clientSecret: cfg.ClientSecret, scope: cfg.Scope}
+	}
+	// Like curl -d, sending data makes the check a POST unless a method
+	// was chosen.
+	if cfg.Data != "" {
+		if cfg.Method == http.MethodGet {
+			cfg.Method = http.MethodPost
+		}
+		if cfg.ContentType == "" {
+			cfg.ContentType = "text/plain; charset=utf-8"
+			if json.Valid([]byte(cfg.Data)) {
+				cfg.ContentType = "application/json"
+			}
+		}
+	}
+	if cfg.PortsRule != "" && len(cfg.Ports) == 0 {
+		return errors.New("ports-rule needs ports")
+	}
+	if len(cfg.Ports) > 0 {
+		cfg.PortsRule = cmp.Or(cfg.PortsRule, "all")
+	}
+	if (cfg.CaptureMin != nil || cfg.CaptureMax != nil) && cfg.Capture == "" {
+		return errors.New("capture-min and capture-max need capture")
+	}
+	if cfg.HTTPSRedirect != "" && cfg.Location != "" {
+		return errors.New("https-redirect follows redirects and cannot be combined with location")
+	}
+	// A virtual host is usually also the name the backend's certificate
+	// is issued for.
+	if cfg.SNI == "" && cfg.Host != "" {
+		cfg.SNI = strings.ToLower(cfg.Host)
+		if host, _, err := net.SplitHostPort(cfg.Host); err == nil {
+			cfg.SNI = strings.ToLower(host)
+		}
+	}
+	return nil
+}
+
+func applyOption(stats *EndpointStats, key, value string) error {
+	cfg := &stats.Config
+	switch key {
+	case "name":
+		stats.Name = value
+	case "severity":
+		if _, ok := severityRank[value]; !ok {
+			return errors.New("expected critical, warning or info")
+		}
+		stats.Severity = value
+	case "code":
+		if !codeRe.MatchString(value) {
+			return errors.New("expected NNN, any, !NNN or not:NXX")
+		}
+		stats.ExpectedCode = value
+	case "codes-file":
+		if stats.ExpectedCode != "" {
+			return errors.New("cannot be combined with an expected code")
+		}
+		// Relative to the file the endpoint is listed in.
+		if i := strings.LastIndex(stats.origin, ":"); i > 0 && !filepath.IsAbs(value) {
+			value = filepath.Join(filepath.Dir(stats.origin[:i]), value)
+		}
+		codes, modTime, err := readCodesFile(value)
+		if err != nil {
+			return err
+		}
+		stats.CodesFile, stats.ExpectedCode, stats.codesMod = value, codes, modTime
+	case "budget":
+		budget, err := time.ParseDuration(value)
+		if err != nil || budget <= 0 {
+			return errors.New("expected a positive duration")
+		}
+		cfg.Budget = budget
+	case "timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return errors.New("expected a positive duration such as 5s")
+		}
+		cfg.Timeout = timeout
+	case "id":
+		if value == "" {
+			return errors.New("id must not be empty")
+		}
+		stats.ID = value
+	case "method":
+		method := strings.ToUpper(value)
+		if method == "" || strings.IndexFunc(method, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+			return errors.New("invalid method")
+		}
+		cfg.Method = method
+	case "body":
+		if value == "" {
+			cfg.Body, cfg.bodyExpr = "", nil
+			return nil
+		}
+		expr, err := parseBodyExpr(value)
+		if err != nil {
+			return err
+		}
+		cfg.Body = value
+		cfg.bodyExpr = expr
+	case "token-url":
+		cfg.TokenURL = value
+	case "client-id":
+		cfg.ClientID = value
+	case "client-secret":
+		cfg.ClientSecret = value
+	case "scope":
+		cfg.Scope = value
+	case "assert-header":
+		assertion, err := parseHeaderAssert(value)
+		if err != nil {
+			return err
+		}
+		cfg.AssertHeaders = append(cfg.AssertHeaders, value)
+		cfg.headerAsserts = append(cfg.headerAsserts, assertion)
+	case "cookie":
+		cookies, err := http.ParseCookie(value)
+		if err != nil {
+			return err
+		}
+		cfg.Cookies = append(cfg.Cookies, cookies...)
+	case "keepalive":
+		keepAlive, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected true or false")
+		}
+		cfg.DisableKeepAlives = !keepAlive
+	case "hash":
+		value = strings.ToLower(value)
+		if _, err := hex.DecodeString(value); value != "change" && (err != nil || len(value) != sha256.Size*2) {
+			return errors.New("expected change or a SHA-256 hex digest")
+		}
+		cfg.Hash = value
+	case "data":
+		cfg.Data = value
+	case "content-type":
+		cfg.ContentType = value
+	case "expect":
+		if pattern, ok := strings.CutPrefix(value, "~"); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return err
+			}
+			cfg.expectRe = re
+		}
+		cfg.Expect = value
+	case "validate":
+		cfg.Validate, cfg.validate = value, strings.Fields(value)
+		if len(cfg.validate) == 0 {
+			cfg.Validate, cfg.validate = "", nil
+		}
+	case "location":
+		if pattern, ok := strings.CutPrefix(value, "~"); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return err
+			}
+			cfg.locationRe = re
+		}
+		cfg.Location = value
+	case "https-redirect":
+		if value != "true" && value != "same-host" {
+			return errors.New("expected true or same-host")
+		}
+		if !strings.HasPrefix(stats.URL, "http://") {
+			return errors.New("requires an http:// URL")
+		}
+		cfg.HTTPSRedirect = value
+	case "host":
+		cfg.Host = value
+	case "sni":
+		cfg.SNI = value
+	case "cert-errors":
+		if value != "down" && value != "warn" {
+			return errors.New("expected down or warn")
+		}
+		cfg.CertErrors = value
+	case "skip-cert-check":
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected true or false")
+		}
+		cfg.SkipCertCheck = skip
+	case "ip-change":
+		ipChange, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected true or false")
+		}
+		cfg.IPChange = ipChange
+	case "cert-change":
+		certChange, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected true or false")
+		}
+		cfg.CertChange = certChange
+	case "capture":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return err
+		}
+		cfg.Capture, cfg.captureRe = value, re
+	case "capture-min", "capture-max":
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("expected a number")
+		}
+		if key == "capture-min" {
+			cfg.CaptureMin = &limit
+		} else {
+			cfg.CaptureMax = &limit
+		}
+	case "anomaly":
+		sigma, err := strconv.ParseFloat(value, 64)
+		if err != nil || sigma <= 0 {
+			return errors.New("expected a positive number of standard deviations, e.g. 3")
+		}
+		cfg.Anomaly = sigma
+	case "warmup":
+		warmup, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected true or false")
+		}
+		cfg.Warmup = warmup
+	case "max-checks":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return errors.New("expected a positive number")
+		}
+		cfg.MaxChecks = n
+	case "ports":
+		for _, item := range strings.Split(value, ",") {
+			m := portItemRe.FindStringSubmatch(item)
+			if m == nil {
+				return fmt.Errorf("%q is not a port, e.g. 9090 or 9090/metrics", item)
+			}
+			if port, _ := strconv.Atoi(m[1]); port < 1 || port > 65535 {
+				return fmt.Errorf("port %s is out of range", m[1])
+			}
+			cfg.Ports = append(cfg.Ports, item)
+		}
+	case "ports-rule":
+		if value != "all" && value != "any" {
+			return errors.New("expected all or any")
+		}
+		cfg.PortsRule = value
+	case "cron":
+		schedule, err := parseCron(value)
+		if err != nil {
+			return err
+		}
+		if schedule.next(time.Now()).IsZero() {
+			return errors.New("schedule never matches")
+		}
+		cfg.Cron, cfg.cron = value, schedule
+	case "source-ip":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return errors.New("expected an IP address")
+		}
+		cfg.SourceIP, cfg.sourceIP = value, ip
+	case "no-proxy":
+		noProxy, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected true or false")
+		}
+		cfg.NoProxy = noProxy
+	default:
+		return errors.New("unknown option")
+	}
+	return nil
+}
+
+// httpsRedirectFailure checks where an https-redirect= endpoint ended up
+// after following its redirects. It returns "" if that is an https URL,
+// on the same host for https-redirect=same-host.
+func httpsRedirectFailure(cfg EndpointConfig, link string, final *url.URL) string {
+	if final.Scheme != "https" {
+		return fmt.Sprintf("ended on %s, want https", final)
+	}
+	if cfg.HTTPSRedirect == "same-host" {
+		if start, err := url.Parse(link); err == nil && !strings.EqualFold(start.Hostname(), final.Hostname()) {
+			return fmt.Sprintf("redirected to %s, want https://%s", final, start.Hostname())
+		}
+	}
+	return ""
+}
+
+// locationFailure checks the Location of an unfollowed redirect against
+// the location= option, accepting the header as sent or resolved against
+// the request URL. It returns "" if it matches.
+func locationFailure(cfg EndpointConfig, resp *http.Response) string {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return fmt.Sprintf("no redirect (Location missing), want %s", cfg.Location)
+	}
+	candidates := []string{location}
+	if resolved, err := resp.Request.URL.Parse(location); err == nil {
+		candidates = append(candidates, resolved.String())
+	}
+	for _, candidate := range candidates {
+		if (cfg.locationRe != nil && cfg.locationRe.MatchString(candidate)) || candidate == cfg.Location {
+			return ""
+		}
+	}
+	return fmt.Sprintf("redirects to %s, want %s", location, cfg.Location)
+}
+
+// headerAssert is an assert-header rule: NAME (must be present),
+// NAME=VALUE (must equal VALUE) or NAME~REGEXP (must match REGEXP).
+type headerAssert struct {
+	name    string
+	exact   bool
+	value   string
+	pattern *regexp.Regexp
+}
+
+func parseHeaderAssert(rule string) (headerAssert, error) {
+	var assertion headerAssert
+	name, rest, op := rule, "", byte(0)
+	if i := strings.IndexAny(rule, "=~"); i >= 0 {
+		name, rest, op = rule[:i], rule[i+1:], rule[i]
+	}
+	assertion.name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	if assertion.name == "" {
+		return assertion, errors.New("assert-header needs a header name")
+	}
+	switch op {
+	case '=':
+		assertion.exact = true
+		assertion.value = rest
+	case '~':
+		pattern, err := regexp.Compile(rest)
+		if err != nil {
+			return assertion, err
+		}
+		assertion.pattern = pattern
+	}
+	return assertion, nil
+}
+
+// failure describes how header fails the rule, or returns "" if it passes.
+func (a headerAssert) failure(header http.Header) string {
+	values, ok := header[a.name]
+	if !ok {
+		return fmt.Sprintf("header %s missing", a.name)
+	}
+	got := strings.Join(values, ", ")
+	switch {
+	case a.pattern != nil && !a.pattern.MatchString(got):
+		return fmt.Sprintf("header %s is %q, want match for %q", a.name, got, a.pattern)
+	case a.exact && got != a.value:
+		return fmt.Sprintf("header %s is %q, want %q", a.name, got, a.value)
+	}
+	return ""
+}
+
+// bodyExpr is a body content rule in disjunctive form: the body passes if
+// every pattern of at least one group is present.
+type bodyExpr [][]string
+
+// parseBodyExpr parses patterns joined with & (all must match) and |
+// (any must match), with & binding tighter, e.g. "version & healthy | ok".
+func parseBodyExpr(text string) (bodyExpr, error) {
+	var expr bodyExpr
+	for _, alternative := range strings.Split(text, "|") {
+		var group []string
+		for _, pattern := range strings.Split(alternative, "&") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				return nil, fmt.Errorf("empty pattern in %q", text)
+			}
+			group = append(group, pattern)
+		}
+		expr = append(expr, group)
+	}
+	return expr, nil
+}
+
+// missing returns the patterns that kept body from matching, or nil if it
+// matches.
+func (e bodyExpr) missing(body string) []string {
+	var missing []string
+	for _, group := range e {
+		var groupMissing []string
+		for _, pattern := range group {
+			if !strings.Contains(body, pattern) {
+				groupMissing = append(groupMissing, pattern)
+			}
+		}
+		if len(groupMissing) == 0 {
+			return nil
+		}
+		missing = append(missing, groupMissing...)
+	}
+	return missing
+}
+
+func handle_endpoint(m *endpointMonitor) {
+	wait := m.firstWait()
+	for sleepCtx(m.ctx, wait) {
+		wait = m.safeStep()
+	}
+	m.stopped()
+}
+
+// stopped logs the end of monitoring. Shutdown is reported by the summary
+// and max-checks by its own message, not as a stop.
+func (m *endpointMonitor) stopped() {
+	if monitorCtx.Err() == nil && !m.completed {
+		log_printf(Yellow, "%s - monitoring stopped\n", m.stats.ID)
+	}
+}
+
+// safeStep runs one step, turning a panic into a log line and a retry
+// after the normal interval, so one bad check cannot silently end the
+// monitoring of its endpoint.
+func (m *endpointMonitor) safeStep() (wait time.Duration) {
+	defer func() {
+		if r := recover(); r != nil {
+			log_printf(Red, "%s - check panicked: %v; restarting in %v\n", m.stats.ID, r, m.normalInterval)
+			wait = m.normalInterval
+		}
+	}()
+	return m.step()
+}
+
+// endpointMonitor carries an endpoint's check loop state from one check to
+// the next, so the loop can be driven by its own goroutine or by the pool.
+type endpointMonitor struct {
+	stats          *EndpointStats
+	ctx            context.Context // of this run; a reload may start another
+	stop           context.CancelFunc
+	completed      bool
+	normalInterval time.Duration
+	currentBackoff time.Duration
+	schedule       *cronSchedule
+	certChecked    time.Time
+	wasUp          bool
+	wasDegraded    bool
+	wasSlow        bool
+	failures       int
+}
+
+func newMonitor(stats *EndpointStats) *endpointMonitor {
+	cfg := stats.config()
+	return &endpointMonitor{
+		stats:          stats,
+		ctx:            stats.ctx,
+		stop:           stats.stop,
+		normalInterval: cfg.Interval,
+		currentBackoff: cfg.Interval,
+		schedule:       cfg.cron,
+		wasUp:          true,
+	}
+}
+
+// firstWait is how long a new monitor waits before its first check:
+// not at all, unless the endpoint runs on a cron= schedule.
+func (m *endpointMonitor) firstWait() time.Duration {
+	if m.schedule == nil {
+		return 0
+	}
+	return time.Until(m.schedule.next(time.Now()))
+}
+
+// step runs one check, logs and alerts on the outcome and returns how long
+// to wait before the next check.
+func (m *endpointMonitor) step() time.Duration {
+	stats := m.stats
+	cfg := stats.config()
+	m.schedule = cfg.cron
+	if cfg.Interval != m.normalInterval {
+		// Changed by a reload.
+		m.normalInterval = cfg.Interval
+		m.currentBackoff = cfg.Interval
+	}
+	// The certificate is checked once, or with cert-change= every
+	// certRecheck to notice a new one.
+	if m.certChecked.IsZero() || cfg.CertChange && time.Since(m.certChecked) >= certRecheck {
+		m.certChecked = time.Now()
+		if strings.HasPrefix(stats.URL, "https") && !cfg.SkipCertCheck {
+			checkSSLCert(stats.URL, stats)
+		}
+	}
+
+	result := checkEndpoint(stats)
+	stats.mu.Lock()
+	// A reload may change it while the alerts below go out.
+	severity := stats.Severity
+	if stats.Config.MaxChecks > 0 && stats.TotalChecks >= int64(stats.Config.MaxChecks) {
+		stats.Completed = true
+		m.completed = true
+		total, state := stats.TotalChecks, stats.State
+		// Logged after the outcome of this last check.
+		defer func() {
+			log_printf(Green, "%s - completed after %d checks (max-checks), final state %s\n", stats.ID, total, state)
+			m.stop()
+		}()
+	}
+	stats.mu.Unlock()
+	// During the startup grace period a failure is not yet an alert; if
+	// the endpoint is still failing afterwards, that check alerts.
+	grace := inStartupGrace()
+	if result.up != m.wasUp && !(grace && !result.up) {
+		kind := "down"
+		if result.up {
+			kind = "recovered"
+			if log_every > 0 {
+				log_printf(Green, "%s - RECOVERED after %d failures\n", stats.ID, m.failures)
+			}
+		}
+		dispatch(alertEvent{Kind: kind, ID: stats.ID, URL: stats.URL, Message: result.message, Time: time.Now(), Severity: severity})
+		m.wasUp = result.up
+	}
+	if result.degraded && !m.wasDegraded && !grace {
+		log_printf(Yellow, "%s - DEGRADED: success rate below %.1f%% over the last %d checks\n", stats.ID, degraded_percent, degraded_window)
+		if degraded_alert {
+			dispatch(alertEvent{Kind: "degraded", ID: stats.ID, URL: stats.URL, Message: stats.ID + " is DEGRADED", Time: time.Now(), Severity: severity})
+		}
+	}
+	if !grace {
+		m.wasDegraded = result.degraded
+	}
+	// Response times are only judged on successful checks. Like a
+	// degradation, slowness still there after the grace period alerts then.
+	if result.up {
+		if result.slow != "" && !m.wasSlow {
+			log_printf(Yellow, "%s\n", result.slow)
+			if !grace {
+				dispatch(alertEvent{Kind: "slow", ID: stats.ID, URL: stats.URL, Message: result.slow, Time: time.Now(), Severity: severity})
+			}
+		} else if result.slow == "" && m.wasSlow {
+			log_printf(Green, "%s - response time back to normal\n", stats.ID)
+		}
+		if !grace {
+			m.wasSlow = result.slow != ""
+		}
+	}
+	if result.capture != "" {
+		if result.captureAlert {
+			log_printf(Yellow, "%s\n", result.capture)
+			if !grace {
+				dispatch(alertEvent{Kind: "threshold", ID: stats.ID, URL: stats.URL, Message: result.capture, Time: time.Now(), Severity: severity})
+			}
+		} else {
+			log_printf(Green, "%s\n", result.capture)
+		}
+	}
+	if result.ipChange != "" {
+		log_printf(Yellow, "%s\n", result.ipChange)
+		if !grace {
+			dispatch(alertEvent{Kind: "ip_changed", ID: stats.ID, URL: stats.URL, Message: result.ipChange, Time: time.Now(), Severity: severity})
+		}
+	}
+	m.failures = result.failures
+
+	// Scheduled endpoints are not retried; the result stands until the
+	// next scheduled check.
+	if m.schedule != nil {
+		next := m.schedule.next(time.Now())
+		if !result.up {
+			if !grace {
+				playAlert()
+			}
+			log_printf(Red, "%s (failures: %d, next check %s)\n", result.message, result.failures, next.Format("2006-01-02 15:04"))
+		} else if show_ok {
+			log_printf(Green, "%s\n", result.message)
+		}
+		return time.Until(next)
+	}
+
+	if !result.up {
+		if !grace {
+			playAlert()
+		}
+		if log_every == 0 || result.failures == 1 || result.failures%log_every == 0 {
+			log_printf(Red, "%s (failures: %d, retry in %v)\n", result.message, result.failures, m.currentBackoff)
+		}
+		wait := m.currentBackoff
+		m.currentBackoff = increaseBackoff(m.currentBackoff)
+		return wait
+	}
+
+	if show_ok {
+		log_printf(Green, "%s\n", result.message)
+	}
+	m.currentBackoff = m.normalInterval
+	return m.normalInterval
+}
+
+// inStartupGrace reports whether the -startup-grace period, during which
+// failures are logged but not alerted, is still running.
+func inStartupGrace() bool {
+	return time.Since(startTime) < startup_grace
+}
+
+// startMonitoring hands a loaded endpoint to the configured scheduler.
+func startMonitoring(stats *EndpointStats) {
+	m := newMonitor(stats)
+	if m.schedule != nil {
+		log_printf(Green, "%s - monitoring started (cron %s, first check %s)\n", stats.ID, stats.config().Cron, m.schedule.next(time.Now()).Format("2006-01-02 15:04"))
+	} else {
+		log_printf(Green, "%s - monitoring started (every %v)\n", stats.ID, m.normalInterval)
+	}
+	if pool != nil {
+		pool.add(m, time.Now().Add(m.firstWait()))
+		return
+	}
+	go handle_endpoint(m)
+}
+
+// scheduler is the -scheduler pool alternative to one goroutine per
+// endpoint: a fixed set of workers runs checks taken from a queue ordered
+// by when each endpoint is next due.
+type scheduler struct {
+	mu    sync.Mutex
+	queue monitorQueue
+	wake  chan struct{}
+}
+
+type scheduledMonitor struct {
+	monitor *endpointMonitor
+	due     time.Time
+}
+
+type monitorQueue []scheduledMonitor
+
+func (q monitorQueue) Len() int           { return len(q) }
+func (q monitorQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q monitorQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *monitorQueue) Push(x any)        { *q = append(*q, x.(scheduledMonitor)) }
+func (q *monitorQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{wake: make(chan struct{}, 1)}
+}
+
+func (s *scheduler) add(m *endpointMonitor, due time.Time) {
+	s.mu.Lock()
+	heap.Push(&s.queue, scheduledMonitor{monitor: m, due: due})
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run starts the workers and feeds them due checks until monitoring stops.
+func (s *scheduler) run(workers int) {
+	work := make(chan *endpointMonitor)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for m := range work {
+				if m.ctx.Err() != nil {
+					m.stopped()
+					continue
+				}
+				s.add(m, time.Now().Add(m.safeStep()))
+			}
+		}()
+	}
+	defer close(work)
+
+	for {
+		s.mu.Lock()
+		wait := time.Hour
+		var next *endpointMonitor
+		if len(s.queue) > 0 {
+			if wait = time.Until(s.queue[0].due); wait <= 0 {
+				next = heap.Pop(&s.queue).(scheduledMonitor).monitor
+			}
+		}
+		s.mu.Unlock()
+
+		if next != nil {
+			select {
+			case work <- next:
+			case <-monitorCtx.Done():
+				return
+			}
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-monitorCtx.Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// sleep waits for d and reports false if monitoring was stopped meanwhile.
+func sleep(d time.Duration) bool {
+	return sleepCtx(monitorCtx, d)
+}
+
+// sleepCtx is sleep for one endpoint, which a reload can stop on its own.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// cronSchedule is a parsed cron= expression: the standard five fields
+// (minute, hour, day of month, month, day of week) as bit sets.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// As in cron, when both day fields are restricted a day matching
+	// either one is due.
+	domAny, dowAny bool
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	if full, ok := cronShortcuts[expr]; ok {
+		expr = full
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("expected 5 fields (minute hour day month weekday)")
+	}
+	c := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	for _, f := range []struct {
+		bits     *uint64
+		text     string
+		min, max int
+	}{
+		{&c.minute, fields[0], 0, 59},
+		{&c.hour, fields[1], 0, 23},
+		{&c.dom, fields[2], 1, 31},
+		{&c.month, fields[3], 1, 12},
+		{&c.dow, fields[4], 0, 7},
+	} {
+		if *f.bits, err = parseCronField(f.text, f.min, f.max); err != nil {
+			return nil, err
+		}
+	}
+	// Sunday may be written as 7.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of *, N, N-M, each
+// optionally followed by /STEP.
+func parseCronField(text string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(text, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+		}
+		lo, hi := min, max
+		if rangeText != "*" {
+			loText, hiText, isRange := strings.Cut(rangeText, "-")
+			var err error
+			if lo, err = strconv.Atoi(loText); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiText); err != nil {
+					return 0, fmt.Errorf("bad value %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first scheduled minute after t, in the -tz timezone,
+// or the zero time if there is none within five years (e.g. February 30).
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.In(location).Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type checkResult struct {
+	up       bool
+	degraded bool
+	failures int
+	message  string
+	reason   string // why a failed check failed, e.g. "got 503, expected 200"
+	ipChange string
+	slow     string // set when an up check was unusually slow (anomaly=)
+	// capture is set when a captured value left its range (captureAlert)
+	// or came back into it.
+	capture      string
+	captureAlert bool
+}
+
+// messageData is what -alert-template and -ok-template have access to.
+type messageData struct {
+	URL          string
+	Method       string
+	Name         string
+	Status       string
+	StatusText   string
+	Expected     string
+	Error        string
+	ResponseTime time.Duration
+	Failures     int
+}
+
+const (
+	defaultAlertTemplate = `{{if ne .Method "GET"}}{{.Method}} {{end}}{{.URL}}{{if .Error}} - {{.Status}}: {{.Error}}{{else}} HAS RETURNED {{.Status}}{{with .StatusText}} {{.}}{{end}} INSTEAD OF {{.Expected}} - POSSIBLE DOWN!!{{end}}`
+	defaultOkTemplate    = `{{if ne .Method "GET"}}{{.Method}} {{end}}{{.URL}} - {{.Status}}{{with .StatusText}} {{.}}{{end}} AS EXPECTED`
+)
+
+// parseMessageTemplate parses a message template and executes it once
+// against sample data so unknown fields are reported at startup.
+func parseMessageTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := messageData{URL: "https://example.com", Method: http.MethodGet, Status: "500", StatusText: "Internal Server Error", Expected: "200", ResponseTime: time.Second, Failures: 1}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func newResult(up bool, data messageData, suffix string) checkResult {
+	tmpl := alert_template
+	if up {
+		tmpl = ok_template
+	}
+	var message strings.Builder
+	if err := tmpl.Execute(&message, data); err != nil {
+		message.Reset()
+		fmt.Fprintf(&message, "%s - %s (template error: %v)", data.URL, data.Status, err)
+	}
+	reason := data.Error
+	if !up && reason == "" {
+		reason = strings.TrimSpace(fmt.Sprintf("got %s %s", data.Status, data.StatusText)) + ", expected " + data.Expected
+	}
+	return checkResult{
+		up:       up,
+		failures: data.Failures,
+		message:  message.String() + suffix,
+		reason:   reason,
+	}
+}
+
+type PhaseTimings struct {
+	DNS     int64 `json:"dns_ms"`
+	Connect int64 `json:"connect_ms"`
+	TLS     int64 `json:"tls_ms"`
+	TTFB    int64 `json:"ttfb_ms"`
+}
+
+// checkTrace follows a request through its phases, remembering which one
+// is in progress (to name it on timeouts) and how long each one took.
+// Callbacks may run on other goroutines, hence the mutex.
+type checkTrace struct {
+	mu                               sync.Mutex
+	phase                            string
+	start                            time.Time
+	dnsStart, connectStart, tlsStart time.Time
+	timings                          PhaseTimings
+	remoteIP, localIP                string
+	addrs                            []string
+	newConns, reusedConns            int64
+}
+
+func (t *checkTrace) begin(phase string, at *time.Time) {
+	t.mu.Lock()
+	t.phase = phase
+	if at != nil {
+		*at = time.Now()
+	}
+	t.mu.Unlock()
+}
+
+func (t *checkTrace) end(from *time.Time, ms *int64) {
+	t.mu.Lock()
+	*ms = time.Since(*from).Milliseconds()
+	t.mu.Unlock()
+}
+
+func (t *checkTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.begin("dns", &t.dnsStart) },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			t.end(&t.dnsStart, &t.timings.DNS)
+			if info.Err == nil {
+				addrs := make([]string, len(info.Addrs))
+				for i, addr := range info.Addrs {
+					addrs[i] = addr.String()
+				}
+				slices.Sort(addrs)
+				t.mu.Lock()
+				t.addrs = addrs
+				t.mu.Unlock()
+			}
+		},
+		ConnectStart:      func(string, string) { t.begin("connect", &t.connectStart) },
+		ConnectDone:       func(string, string, error) { t.end(&t.connectStart, &t.timings.Connect) },
+		TLSHandshakeStart: func() { t.begin("tls handshake", &t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.end(&t.tlsStart, &t.timings.TLS) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			if info.Reused {
+				t.reusedConns++
+			} else {
+				t.newConns++
+			}
+			if host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+				t.remoteIP = host
+			}
+			if host, _, err := net.SplitHostPort(info.Conn.LocalAddr().String()); err == nil {
+				t.localIP = host
+			}
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { t.begin("response headers", nil) },
+		GotFirstResponseByte: func() {
+			t.begin("response body", nil)
+			t.end(&t.start, &t.timings.TTFB)
+		},
+	}
+}
+
+func (t *checkTrace) result() (string, PhaseTimings, string, string, []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.phase, t.timings, t.remoteIP, t.localIP, t.addrs
+}
+
+// conns returns how many connections the check opened and how many it
+// took from the idle pool; a followed redirect can use more than one.
+func (t *checkTrace) conns() (opened, reused int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.newConns, t.reusedConns
+}
+
+// checkEndpoint performs a single request against stats.URL, records the
+// outcome on stats and returns it. It is shared by the monitoring loop and
+// the -once mode so both judge endpoints the same way.
+func checkEndpoint(stats *EndpointStats) (result checkResult) {
+	// A reload may replace the settings; use them as they are now.
+	stats.mu.Lock()
+	link := stats.URL
+	awaited_answer := stats.ExpectedCode
+	cfg := stats.Config
+	httpClient := stats.client
+	parts := stats.parts
+	stats.mu.Unlock()
+	if parts != nil {
+		return checkPorts(stats, parts, awaited_answer)
+	}
+	target := cmp.Or(cfg.socketURL, link)
+
+	// The warm-up request's outcome is ignored; the check below is what
+	// counts.
+	if cfg.Warmup {
+		if req, err := newCheckRequest(context.Background(), cfg, target, httpClient); err == nil {
+			if resp, err := httpClient.Do(req); err == nil {
+				io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodyBytes))
+				resp.Body.Close()
+			}
+		}
+	}
+
+	trace := &checkTrace{}
+	req, err := newCheckRequest(httptrace.WithClientTrace(context.Background(), trace.clientTrace()), cfg, target, httpClient)
+
+	var resp *http.Response
+	start := time.Now()
+	trace.start = start
+	if err == nil {
+		resp, err = httpClient.Do(req)
+	}
+	responseTime := time.Since(start)
+
+	// The body is read, and a validate= command run, before stats is
+	// locked, as either can take a while. The client's timeout keeps
+	// running while the body is read, so a body that never ends is cut
+	// off at the endpoint's timeout, or after maxBodyBytes.
+	var body []byte
+	var bodyErr, validateErr error
+	if err == nil {
+		if cfg.bodyExpr != nil || cfg.Hash != "" || cfg.Expect != "" || cfg.validate != nil || cfg.captureRe != nil {
+			var decoded io.Reader
+			if decoded, bodyErr = decodeBody(resp); bodyErr == nil {
+				body, bodyErr = io.ReadAll(io.LimitReader(decoded, maxBodyBytes))
+			}
+		}
+		resp.Body.Close()
+		if cfg.validate != nil && bodyErr == nil && codeMatches(awaited_answer, strconv.Itoa(resp.StatusCode)) {
+			validateErr = runValidator(cfg.validate, link, resp, body)
+		}
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	// Runs before the unlock above, once the outcome is known.
+	defer finishCheck(stats, cfg, responseTime, &result)
+	stats.TotalChecks++
+	stats.LastCheck = time.Now()
+	recordCheckTime(stats, start)
+	stats.LastResponseTime = responseTime.Milliseconds()
+	stats.responseTime = responseTime
+	phase, timings, remoteIP, localIP, addrs := trace.result()
+	if conn_stats {
+		recordConns(stats, trace)
+	}
+	stats.LastTimings = timings
+	// Set again below if this check timed out too.
+	stats.LastTimeoutPhase = ""
+	if remoteIP != "" {
+		stats.ResolvedIP = remoteIP
+	}
+	if localIP != "" {
+		stats.SourceIP = localIP
+	}
+	// Only checks that opened a new connection looked the name up.
+	if addrs != nil {
+		if cfg.IPChange && stats.ResolvedIPs != nil && !slices.Equal(addrs, stats.ResolvedIPs) {
+			ipChange := fmt.Sprintf("%s - resolved IPs changed from %s to %s",
+				stats.ID, strings.Join(stats.ResolvedIPs, ", "), strings.Join(addrs, ", "))
+			defer func() { result.ipChange = ipChange }()
+		}
+		stats.ResolvedIPs = addrs
+	}
+
+	data := messageData{
+		URL:          link,
+		Method:       cfg.Method,
+		Name:         stats.Name,
+		Expected:     awaited_answer,
+		ResponseTime: responseTime.Round(rt_precision),
+	}
+
+	if err != nil {
+		stats.ConsecFailures++
+		stats.IsUp = false
+		stats.LastStatusText = ""
+		data.Failures = stats.ConsecFailures
+		var netErr net.Error
+		var certErr *tls.CertificateVerificationError
+		switch {
+		case errors.As(err, &certErr):
+			stats.LastStatus = "CERT ERROR"
+			data.Error = certErr.Err.Error()
+		case errors.Is(err, errTokenRefresh):
+			stats.LastStatus = "AUTH ERROR"
+			data.Error = err.Error()
+		case errors.Is(err, errRedirectLoop):
+			stats.LastStatus = "REDIRECT LOOP"
+			data.Error = fmt.Sprintf("more than %d redirects", max_redirects)
+		// net/http has no error value to match for this.
+		case strings.Contains(err.Error(), "server response headers exceeded"):
+			stats.LastStatus = "HEADERS TOO LARGE"
+			data.Error = fmt.Sprintf("response headers larger than %d bytes (-max-header-bytes)", max_header_bytes)
+		case errors.As(err, &netErr) && netErr.Timeout() && phase != "":
+			stats.LastStatus = "ERROR"
+			stats.LastTimeoutPhase = phase
+			data.Error = fmt.Sprintf("timeout in %s phase: %v", phase, err)
+		default:
+			stats.LastStatus = "ERROR"
+			data.Error = err.Error()
+		}
+		data.Status = stats.LastStatus
+		return newResult(false, data, "")
+	}
+	rtSuffix := ""
+	if show_rt {
+		rtSuffix = fmt.Sprintf(" [%v]", responseTime.Round(rt_precision))
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && cfg.oauth != nil {
+		cfg.oauth.invalidate()
+	}
+
+	answer := strconv.Itoa(resp.StatusCode)
+	stats.LastStatus = answer
+	stats.LastStatusText = http.StatusText(resp.StatusCode)
+	stats.FinalURL = resp.Request.URL.String()
+	if len(capture_headers) > 0 {
+		stats.CapturedHeaders = captureHeaders(resp.Header)
+	}
+	data.Status = answer
+	data.StatusText = stats.LastStatusText
+	if cfg.captureRe != nil {
+		if message, alert := recordCapture(stats, cfg, body, bodyErr); message != "" {
+			defer func() { result.capture, result.captureAlert = message, alert }()
+		}
+	}
+
+	if !redirectPolicyMatches(cfg, awaited_answer, answer) {
+		stats.ConsecFailures++
+		stats.IsUp = false
+		data.Failures = stats.ConsecFailures
+		return newResult(false, data, rtSuffix)
+	}
+
+	if cfg.Location != "" {
+		if failure := locationFailure(cfg, resp); failure != "" {
+			stats.ConsecFailures++
+			stats.IsUp = false
+			stats.LastStatus = "REDIRECT MISMATCH"
+			stats.LastStatusText = ""
+			data.Status = stats.LastStatus
+			data.StatusText = ""
+			data.Failures = stats.ConsecFailures
+			data.Error = failure
+			return newResult(false, data, rtSuffix)
+		}
+	}
+
+	if cfg.HTTPSRedirect != "" {
+		if failure := httpsRedirectFailure(cfg, link, resp.Request.URL); failure != "" {
+			stats.ConsecFailures++
+			stats.IsUp = false
+			stats.LastStatus = "NO HTTPS REDIRECT"
+			stats.LastStatusText = ""
+			data.Status = stats.LastStatus
+			data.StatusText = ""
+			data.Failures = stats.ConsecFailures
+			data.Error = failure
+			return newResult(false, data, rtSuffix)
+		}
+	}
+
+	for _, assertion := range cfg.headerAsserts {
+		if failure := assertion.failure(resp.Header); failure != "" {
+			stats.ConsecFailures++
+			stats.IsUp = false
+			stats.LastStatus = "HEADER MISMATCH"
+			stats.LastStatusText = ""
+			data.Status = stats.LastStatus
+			data.StatusText = ""
+			data.Failures = stats.ConsecFailures
+			data.Error = failure
+			return newResult(false, data, rtSuffix)
+		}
+	}
+
+	// A body still arriving at the timeout fails every content check the
+	// same way, instead of as a mismatch against a partial body.
+	var netErr net.Error
+	if errors.As(bodyErr, &netErr) && netErr.Timeout() {
+		stats.ConsecFailures++
+		stats.IsUp = false
+		stats.LastStatus = "BODY READ TIMEOUT"
+		stats.LastStatusText = ""
+		data.Status = stats.LastStatus
+		data.StatusText = ""
+		data.Failures = stats.ConsecFailures
+		data.Error = fmt.Sprintf("body not complete after %v (%d bytes read)", cfg.Timeout, len(body))
+		return newResult(false, data, rtSuffix)
+	}
+
+	if cfg.bodyExpr != nil {
+		var missing []string
+		if bodyErr == nil {
+			missing = cfg.bodyExpr.missing(string(body))
+		}
+		if bodyErr != nil || len(missing) > 0 {
+			stats.ConsecFailures++
+			stats.IsUp = false
+			stats.LastStatus = "CONTENT MISMATCH"
+			stats.LastStatusText = ""
+			data.Status = stats.LastStatus
+			data.StatusText = ""
+			data.Failures = stats.ConsecFailures
+			if bodyErr != nil {
+				data.Error = fmt.Sprintf("reading body: %v", bodyErr)
+			} else {
+				data.Error = "body missing " + quoteList(missing)
+			}
+			return newResult(false, data, rtSuffix)
+		}
+	}
+
+	if cfg.Expect != "" {
+		received := strings.TrimSpace(string(body))
+		matched := received == cfg.Expect
+		if cfg.expectRe != nil {
+			matched = cfg.expectRe.MatchString(received)
+		}
+		if bodyErr != nil || !matched {
+			stats.ConsecFailures++
+			stats.IsUp = false
+			stats.LastStatus = "RESPONSE MISMATCH"
+			stats.LastStatusText = ""
+			data.Status = stats.LastStatus
+			data.StatusText = ""
+			data.Failures = stats.ConsecFailures
+			if bodyErr != nil {
+				data.Error = fmt.Sprintf("reading body: %v", bodyErr)
+			} else {
+				data.Error = fmt.Sprintf("sent %q, received %q, want %q",
+					truncate(cfg.Data, 80), truncate(received, 80), cfg.Expect)
+			}
+			return newResult(false, data, rtSuffix)
+		}
+	}
+
+	// hash=change compares against the previous check, a pinned digest
+	// against itself; the first check in change mode only records the hash.
+	if cfg.Hash != "" {
+		want := cfg.Hash
+		if want == "change" {
+			want = stats.BodyHash
+		}
+		var hash string
+		if bodyErr == nil {
+			sum := sha256.Sum256(body)
+			hash = hex.EncodeToString(sum[:])
+			stats.BodyHash = hash
+		}
+		if bodyErr != nil || (want != "" && hash != want) {
+			stats.ConsecFailures++
+			stats.IsUp = false
+			stats.LastStatus = "CONTENT CHANGED"
+			stats.LastStatusText = ""
+			data.Status = stats.LastStatus
+			data.StatusText = ""
+			data.Failures = stats.ConsecFailures
+			if bodyErr != nil {
+				data.Error = fmt.Sprintf("reading body: %v", bodyErr)
+			} else {
+				data.Error = fmt.Sprintf("body hash is %s, expected %s", hash, want)
+			}
+			return newResult(false, data, rtSuffix)
+		}
+	}
+
+	if cfg.validate != nil && (bodyErr != nil || validateErr != nil) {
+		stats.ConsecFailures++
+		stats.IsUp = false
+		stats.LastStatus = "VALIDATION FAILED"
+		stats.LastStatusText = ""
+		data.Status = stats.LastStatus
+		data.StatusText = ""
+		data.Failures = stats.ConsecFailures
+		if bodyErr != nil {
+			data.Error = fmt.Sprintf("reading body: %v", bodyErr)
+		} else {
+			data.Error = validateErr.Error()
+		}
+		return newResult(false, data, rtSuffix)
+	}
+
+	stats.SuccessfulChecks++
+	stats.ConsecFailures = 0
+	stats.IsUp = true
+	stats.LastSuccess = stats.LastCheck
+	return newResult(true, data, rtSuffix)
+}
+
+// newCheckRequest builds the request an endpoint is checked with.
+func newCheckRequest(ctx context.Context, cfg EndpointConfig, link string, httpClient *http.Client) (*http.Request, error) {
+	var reqBody io.Reader
+	if cfg.Data != "" {
+		reqBody = strings.NewReader(cfg.Data)
+	}
+	req, err := http.NewRequestWithContext(ctx, cfg.Method, link, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ContentType != "" {
+		req.Header.Set("Content-Type", cfg.ContentType)
+	}
+	if cfg.Host != "" {
+		req.Host = cfg.Host
+	}
+	for _, cookie := range cfg.Cookies {
+		req.AddCookie(cookie)
+	}
+	if cfg.oauth != nil {
+		token, err := cfg.oauth.get(httpClient)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// finishCheck records the outcome of a check in the endpoint's history
+// and state. stats.mu must be held.
+func finishCheck(stats *EndpointStats, cfg EndpointConfig, responseTime time.Duration, result *checkResult) {
+	recordDaily(stats, result.up)
+	recordLatency(stats, cfg.Budget, responseTime)
+	if result.up {
+		result.slow = recordBaseline(stats, cfg.Anomaly, responseTime)
+	}
+	recordOutage(stats, result.up)
+	result.degraded = recordRecent(stats, result.up)
+	stats.State = stateOf(stats)
+	stats.LastError = result.reason
+	// The ports of a ports= endpoint are recorded as the endpoint.
+	if !stats.part {
+		metrics_log.record(stats, result.up)
+	}
+}
+
+// portCheck is the latest result on one port of a ports= endpoint.
+type portCheck struct {
+	Port           string `json:"port"`
+	URL            string `json:"url"`
+	IsUp           bool   `json:"is_up"`
+	Status         string `json:"status"`
+	Error          string `json:"error,omitempty"`
+	ResponseTimeMs int64  `json:"response_time_ms"`
+}
+
+// checkPorts checks every port of a ports= endpoint at once and rolls the
+// results up: the endpoint is up if all of them passed, or with
+// ports-rule=any if at least one did. Its response time is the slowest
+// port's. Every port expects the endpoint's current code, which a
+// codes-file refresh may have changed.
+func checkPorts(stats *EndpointStats, parts []*EndpointStats, expected string) (result checkResult) {
+	start := time.Now()
+	results := make([]checkResult, len(parts))
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		part.mu.Lock()
+		part.ExpectedCode = expected
+		part.mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// As in safeStep, a panic fails this port instead of ending
+			// the process.
+			defer func() {
+				if r := recover(); r != nil {
+					log_printf(Red, "%s - check panicked: %v\n", part.ID, r)
+					reason := fmt.Sprintf("check panicked: %v", r)
+					results[i] = checkResult{message: part.ID + " - " + reason, reason: reason}
+				}
+			}()
+			results[i] = checkEndpoint(part)
+		}()
+	}
+	wg.Wait()
+
+	checks := make([]portCheck, len(parts))
+	var responseTime time.Duration
+	var failed []string
+	for i, part := range parts {
+		part.mu.Lock()
+		checks[i] = portCheck{
+			Port:           part.Name,
+			URL:            part.URL,
+			IsUp:           results[i].up,
+			Status:         part.LastStatus,
+			Error:          results[i].reason,
+			ResponseTimeMs: part.LastResponseTime,
+		}
+		responseTime = max(responseTime, part.responseTime)
+		part.mu.Unlock()
+		if !results[i].up {
+			failed = append(failed, part.Name+" "+results[i].reason)
+		}
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	cfg := stats.Config
+	defer finishCheck(stats, cfg, responseTime, &result)
+	stats.TotalChecks++
+	stats.LastCheck = time.Now()
+	recordCheckTime(stats, start)
+	stats.LastResponseTime = responseTime.Milliseconds()
+	stats.responseTime = responseTime
+	stats.PortChecks = checks
+	stats.LastStatus = fmt.Sprintf("%d/%d PORTS UP", len(parts)-len(failed), len(parts))
+	stats.LastStatusText = ""
+
+	data := messageData{
+		URL:          stats.URL,
+		Method:       cfg.Method,
+		Name:         stats.Name,
+		Status:       stats.LastStatus,
+		Expected:     stats.ExpectedCode,
+		ResponseTime: responseTime.Round(rt_precision),
+		Error:        strings.Join(failed, "; "),
+	}
+	rtSuffix := ""
+	if show_rt {
+		rtSuffix = fmt.Sprintf(" [%v]", responseTime.Round(rt_precision))
+	}
+	if len(failed) == len(parts) || len(failed) > 0 && cfg.PortsRule == "all" {
+		stats.ConsecFailures++
+		stats.IsUp = false
+		data.Failures = stats.ConsecFailures
+		return newResult(false, data, rtSuffix)
+	}
+	stats.SuccessfulChecks++
+	stats.ConsecFailures = 0
+	stats.IsUp = true
+	stats.LastSuccess = stats.LastCheck
+	// A port that is down under ports-rule=any does not fail the check,
+	// but still shows in the reason column.
+	data.Error = ""
+	result = newResult(true, data, rtSuffix)
+	result.reason = strings.Join(failed, "; ")
+	return result
+}
+
+// probe checks a single endpoint line once, so the binary can serve as a
+// generic HTTP probe (e.g. a Kubernetes exec probe), and returns the exit
+// code: 0 if it passed, 1 if it failed or the line is invalid.
+func probe(line string) int {
+	stats := regex_to_handle(strings.TrimSpace(line), "-probe")
+	if stats == nil {
+		return 1
+	}
+	result := checkEndpoint(stats)
+	if !result.up {
+		color_printf(Red, "%s\n", result.message)
+		return 1
+	}
+	color_printf(Green, "%s\n", result.message)
+	return 0
+}
+
+// runOnce checks every loaded endpoint a single time and reports whether all
+// of them matched their expected response.
+func runOnce() bool {
+	// Reported in config order, whatever order the checks finish in, so
+	// that runs can be compared.
+	list := orderedEndpoints()
+	results := make([]checkResult, len(list))
+	var wg sync.WaitGroup
+	for i, stats := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if strings.HasPrefix(stats.URL, "https") && !stats.config().SkipCertCheck {
+				checkSSLCert(stats.URL, stats)
+			}
+			results[i] = checkEndpoint(stats)
+		}()
+	}
+	wg.Wait()
+
+	allUp := true
+	for _, result := range results {
+		if result.up {
+			log_printf(Green, "%s\n", result.message)
+		} else {
+			allUp = false
+			log_printf(Red, "%s\n", result.message)
+		}
+	}
+	return allUp
+}
+
+// recordDaily adds a check outcome to today's bucket, dropping buckets
+// older than -daily-days. stats.mu must be held.
+func recordDaily(stats *EndpointStats, up bool) {
+	date := stats.LastCheck.In(location).Format("2006-01-02")
+	if n := len(stats.Daily); n == 0 || stats.Daily[n-1].Date != date {
+		stats.Daily = append(stats.Daily, &DailyStats{Date: date})
+		stats.Daily = keepLast(stats.Daily, daily_days)
+	}
+	day := stats.Daily[len(stats.Daily)-1]
+	day.TotalChecks++
+	if up {
+		day.SuccessfulChecks++
+	}
+}
+
+// codeMatches reports whether a returned status code satisfies the expected
+// code of an endpoint. "any" accepts every HTTP response, and "!500" or
+// "not:5xx" accept everything except the negated code or class. The codes
+// from a codes-file are alternatives separated by "|".
+func codeMatches(expected, answer string) bool {
+	if strings.Contains(expected, "|") {
+		for _, alternative := range strings.Split(expected, "|") {
+			if codeMatches(alternative, answer) {
+				return true
+			}
+		}
+		return false
+	}
+	if negated, ok := strings.CutPrefix(expected, "!"); ok {
+		return !codePatternMatches(negated, answer)
+	}
+	if negated, ok := strings.CutPrefix(expected, "not:"); ok {
+		return !codePatternMatches(negated, answer)
+	}
+	return expected == "any" || expected == answer
+}
+
+// decodeBody returns a reader for resp's body with a gzip or deflate
+// Content-Encoding removed, so content checks match the real content. The
+// transport only does this itself for gzip, and only when it added the
+// Accept-Encoding header to the request.
+func decodeBody(resp *http.Response) (io.Reader, error) {
+	if resp.Uncompressed {
+		return resp.Body, nil
+	}
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing gzip body: %w", err)
+		}
+		return decodingReader{r, encoding}, nil
+	case "deflate":
+		// Meant to be zlib-wrapped, but some servers send raw deflate.
+		br := bufio.NewReader(resp.Body)
+		if header, err := br.Peek(2); err == nil && header[0]&0x0f == 8 && (int(header[0])<<8|int(header[1]))%31 == 0 {
+			r, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("decompressing deflate body: %w", err)
+			}
+			return decodingReader{r, encoding}, nil
+		}
+		return decodingReader{flate.NewReader(br), encoding}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+}
+
+// decodingReader says which decompression failed in its read errors.
+type decodingReader struct {
+	r        io.Reader
+	encoding string
+}
+
+func (d decodingReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("decompressing %s body: %w", d.encoding, err)
+	}
+	return n, err
+}
+
+// codesFileRefresh is how often codes-file files are checked for changes.
+const codesFileRefresh = 10 * time.Second
+
+// readCodesFile reads the expected codes listed in a codes-file, separated
+// by spaces, commas or newlines, with # starting a comment. It returns them
+// joined with "|" as codeMatches takes them.
+func readCodesFile(path string) (string, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var codes []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		for _, code := range strings.Fields(strings.ReplaceAll(line, ",", " ")) {
+			if !codeRe.MatchString(code) {
+				return "", time.Time{}, fmt.Errorf("%s: invalid code %q", path, code)
+			}
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return "", time.Time{}, fmt.Errorf("%s lists no codes", path)
+	}
+	return strings.Join(codes, "|"), info.ModTime(), nil
+}
+
+// refreshCodeFiles re-reads changed codes-file files, so expectations can
+// change without a restart. A file that has become invalid is reported and
+// the previous codes are kept.
+func refreshCodeFiles() {
+	for sleep(codesFileRefresh) {
+		for _, stats := range orderedEndpoints() {
+			refreshCodesFile(stats)
+		}
+	}
+}
+
+// refreshCodesFile re-reads an endpoint's codes-file if it was modified.
+func refreshCodesFile(stats *EndpointStats) {
+	stats.mu.Lock()
+	path, modTime := stats.CodesFile, stats.codesMod
+	stats.mu.Unlock()
+	if path == "" {
+		return
+	}
+	if info, err := os.Stat(path); err == nil && info.ModTime().Equal(modTime) {
+		return
+	}
+	codes, newMod, err := readCodesFile(path)
+	if err != nil {
+		log_printf(Yellow, "%s - keeping expected codes: %v\n", stats.ID, err)
+		// Report it once, not on every refresh.
+		if newMod.IsZero() {
+			if info, statErr := os.Stat(path); statErr == nil {
+				newMod = info.ModTime()
+			}
+		}
+		stats.mu.Lock()
+		stats.codesMod = newMod
+		stats.mu.Unlock()
+		return
+	}
+	stats.mu.Lock()
+	changed := stats.ExpectedCode != codes
+	stats.ExpectedCode, stats.codesMod = codes, newMod
+	stats.mu.Unlock()
+	if changed {
+		log_printf(Green, "%s - expected codes are now %s (from %s)\n", stats.ID, codes, path)
+	}
+}
+
+// redirectPolicyMatches is codeMatches with -redirect-policy applied to
+// redirects that were not followed. An expected code given on the line
+// still decides for up, while down fails any 3xx that is not exactly the
+// expected code, even with "any". Endpoints checking the redirect through
+// location= or https-redirect= are left alone.
+func redirectPolicyMatches(cfg EndpointConfig, expected, answer string) bool {
+	matched := codeMatches(expected, answer)
+	if !strings.HasPrefix(answer, "3") || cfg.Location != "" || cfg.HTTPSRedirect != "" {
+		return matched
+	}
+	switch redirect_policy {
+	case "up":
+		return matched || cfg.defaultCode
+	case "down":
+		return slices.Contains(strings.Split(expected, "|"), answer)
+	}
+	return matched
+}
+
+// codePatternMatches matches a three character code where x is a wildcard
+// digit, e.g. "5xx".
+func codePatternMatches(pattern, code string) bool {
+	if len(pattern) != len(code) {
+		return false
+	}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != 'x' && pattern[i] != 'X' && pattern[i] != code[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validateTimeout bounds how long a validate= command may run.
+const validateTimeout = 10 * time.Second
+
+// runValidator runs a validate= command with the response on its standard
+// input: the status line, the headers, an empty line and the body. The
+// check fails if the command exits non-zero or does not finish in time.
+func runValidator(command []string, link string, resp *http.Response, body []byte) error {
+	var input bytes.Buffer
+	fmt.Fprintf(&input, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&input)
+	input.WriteString("\r\n")
+	input.Write(body)
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = &input
+	cmd.Env = append(os.Environ(), "UPTIMER_URL="+link, "UPTIMER_STATUS="+strconv.Itoa(resp.StatusCode))
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s did not finish within %v", command[0], validateTimeout)
+	}
+	if err != nil {
+		// The first line of output usually says what is wrong.
+		if line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n"); line != "" {
+			return fmt.Errorf("%v: %s", err, truncate(strings.TrimSpace(line), 200))
+		}
+		return err
+	}
+	return nil
+}
+
+// truncate shortens text for messages, marking where it was cut.
+func truncate(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+	return text[:limit] + "..."
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Endpoint states. An endpoint is pending until its first check completes,
+// so nothing is shown as UP or DOWN before it has actually been checked.
+const (
+	statePending  = "pending"
+	stateUp       = "up"
+	stateDown     = "down"
+	stateDegraded = "degraded"
+)
+
+func stateOf(stats *EndpointStats) string {
+	switch {
+	case stats.TotalChecks == 0:
+		return statePending
+	case !stats.IsUp:
+		return stateDown
+	case stats.IsDegraded:
+		return stateDegraded
+	}
+	return stateUp
+}
+
+// stateRank orders endpoints so the ones needing attention come first.
+var stateRank = map[string]int{stateDown: 0, stateDegraded: 1, statePending: 2, stateUp: 3}
+
+// orderedEndpoints returns the endpoints in the order they were loaded,
+// across config files and includes.
+func orderedEndpoints() []*EndpointStats {
+	type entry struct {
+		stats *EndpointStats
+		order int
+	}
+	endpointsMu.RLock()
+	entries := make([]entry, 0, len(endpoints))
+	for _, stats := range endpoints {
+		entries = append(entries, entry{stats, stats.order})
+	}
+	endpointsMu.RUnlock()
+	slices.SortFunc(entries, func(a, b entry) int { return cmp.Compare(a.order, b.order) })
+	list := make([]*EndpointStats, len(entries))
+	for i, e := range entries {
+		list[i] = e.stats
+	}
+	return list
+}
+
+// sortedEndpoints returns the endpoints as the dashboard lists them: by
+// severity, critical first, and within a severity down, degraded, pending
+// and then up, each group in load order.
+func sortedEndpoints() []*EndpointStats {
+	type entry struct {
+		stats    *EndpointStats
+		severity int
+		rank     int
+	}
+	var list []entry
+	for _, stats := range orderedEndpoints() {
+		stats.mu.Lock()
+		list = append(list, entry{stats, severityRank[stats.Severity], stateRank[stats.State]})
+		stats.mu.Unlock()
+	}
+
+	slices.SortStableFunc(list, func(a, b entry) int {
+		return cmp.Or(cmp.Compare(a.severity, b.severity), cmp.Compare(a.rank, b.rank))
+	})
+	sorted := make([]*EndpointStats, len(list))
+	for i, e := range list {
+		sorted[i] = e.stats
+	}
+	return sorted
+}
+
+// config returns the endpoint's settings as they are now; a reload may
+// replace them at any time.
+func (s *EndpointStats) config() EndpointConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Config
+}
+
+// uptimePercent is the share of successful checks; stats.mu must be held.
+func (s *EndpointStats) uptimePercent() float64 {
+	if s.TotalChecks == 0 {
+		return 0
+	}
+	return float64(s.SuccessfulChecks) / float64(s.TotalChecks) * 100
+}
+
+// keepLast trims a per-endpoint history buffer to its newest n entries.
+// Every buffer's n is at most -history, which bounds memory per endpoint.
+func keepLast[T any](buf []T, n int) []T {
+	if len(buf) > n {
+		return buf[len(buf)-n:]
+	}
+	return buf
+}
+
+// latencyStats counts checks against an endpoint's budget= latency budget,
+// for an SLO-style "99.5% of checks within 200ms" view.
+type latencyStats struct {
+	Budget      string  `json:"budget"`
+	Checks      int64   `json:"checks"` // made while a budget was set
+	OverBudget  int64   `json:"over_budget_checks"`
+	FastPercent float64 `json:"fast_percent"`
+}
+
+// recordLatency counts a check that took longer than budget, whether it
+// succeeded or not. stats.mu must be held.
+func recordLatency(stats *EndpointStats, budget, responseTime time.Duration) {
+	if budget <= 0 {
+		return
+	}
+	if stats.Latency == nil {
+		stats.Latency = &latencyStats{}
+	}
+	stats.Latency.Budget = budget.String()
+	stats.Latency.Checks++
+	if responseTime > budget {
+		stats.Latency.OverBudget++
+	}
+	stats.Latency.FastPercent = float64(stats.Latency.Checks-stats.Latency.OverBudget) / float64(stats.Latency.Checks) * 100
+}
+
+// recordOutage counts incidents, each starting with the first failure
+// after a success, and keeps the longest outage: from the first failed
+// check to the check that found the endpoint up again, or to the latest
+// check while it is still down. stats.mu must be held.
+func recordOutage(stats *EndpointStats, up bool) {
+	switch {
+	case !up && stats.ConsecFailures == 1:
+		stats.Incidents++
+		stats.LastIncident = stats.LastCheck
+		stats.inOutage = true
+	case up && stats.inOutage:
+		stats.inOutage = false
+	case !stats.inOutage:
+		return
+	}
+	if outage := stats.LastCheck.Sub(stats.LastIncident); outage > stats.longestOutage {
+		stats.longestOutage = outage
+		stats.LongestOutage = outage.Round(time.Second).String()
+	}
+}
+
+// connStats counts the connections used by an endpoint's checks, to see
+// whether keep-alives help: a low reuse share means the server closes idle
+// connections or -max-idle-per-host is too small.
+type connStats struct {
+	New          int64   `json:"new"`
+	Reused       int64   `json:"reused"`
+	ReusePercent float64 `json:"reuse_percent"`
+}
+
+// recordConns adds a check's connections to stats.Connections (with
+// -conn-stats). stats.mu must be held.
+func recordConns(stats *EndpointStats, trace *checkTrace) {
+	opened, reused := trace.conns()
+	if opened+reused == 0 {
+		return
+	}
+	if stats.Connections == nil {
+		stats.Connections = &connStats{}
+	}
+	stats.Connections.New += opened
+	stats.Connections.Reused += reused
+	stats.Connections.ReusePercent = float64(stats.Connections.Reused) / float64(stats.Connections.New+stats.Connections.Reused) * 100
+}
+
+// Rolling response time baseline for anomaly=. A check is only judged
+// once the baseline has anomalyMinSamples checks, and it must also be at
+// least anomalyMinDelta slower than the mean, so that a very steady
+// endpoint is not flagged for a few milliseconds of jitter.
+const (
+	anomalyWindow     = 100
+	anomalyMinSamples = 20
+	anomalyMinDelta   = 50 * time.Millisecond
+)
+
+// baselineStats is the usual response time of an endpoint with anomaly=,
+// kept with Welford's algorithm over its successful checks.
+type baselineStats struct {
+	MeanMs   float64 `json:"mean_ms"`
+	StdDevMs float64 `json:"stddev_ms"`
+	Samples  int     `json:"samples"`
+	Slow     bool    `json:"slow"`
+	m2       float64
+}
+
+// recordBaseline judges a successful check's response time against the
+// baseline and, unless it was unusually slow, adds it to the baseline.
+// Slow checks are left out, so a lasting slowdown stays visible instead of
+// becoming the new normal. It returns a description if the check was slow.
+// stats.mu must be held.
+func recordBaseline(stats *EndpointStats, sigma float64, responseTime time.Duration) string {
+	if sigma <= 0 {
+		return ""
+	}
+	if stats.Baseline == nil {
+		stats.Baseline = &baselineStats{}
+	}
+	b := stats.Baseline
+	ms := float64(responseTime) / float64(time.Millisecond)
+	if b.Samples >= anomalyMinSamples && b.StdDevMs > 0 {
+		deviations := (ms - b.MeanMs) / b.StdDevMs
+		if deviations > sigma && ms-b.MeanMs >= float64(anomalyMinDelta/time.Millisecond) {
+			b.Slow = true
+			usual := time.Duration(b.MeanMs * float64(time.Millisecond))
+			return fmt.Sprintf("%s - SLOW: %v is %.1f standard deviations above the usual %v",
+				stats.ID, responseTime.Round(rt_precision), deviations, usual.Round(rt_precision))
+		}
+	}
+	b.Slow = false
+
+	// Capping the count at anomalyWindow (and decaying m2 to match) makes
+	// older checks fade out, so the baseline follows gradual change.
+	if b.Samples == anomalyWindow {
+		b.m2 *= float64(anomalyWindow-1) / anomalyWindow
+	} else {
+		b.Samples++
+	}
+	delta := ms - b.MeanMs
+	b.MeanMs += delta / float64(b.Samples)
+	b.m2 += delta * (ms - b.MeanMs)
+	if b.Samples > 1 {
+		b.StdDevMs = math.Sqrt(b.m2 / float64(b.Samples-1))
+	}
+	return ""
+}
+
+// captureHistory is how many captured values are kept per endpoint.
+const captureHistory = 100
+
+// captureStats tracks the number a capture= regexp picks out of the body.
+type captureStats struct {
+	Value      *float64       `json:"value"`           // latest, nil until a number was captured
+	Error      string         `json:"error,omitempty"` // why the last check captured no number
+	OutOfRange bool           `json:"out_of_range"`
+	History    []capturePoint `json:"history"`
+}
+
+type capturePoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// recordCapture extracts the capture= value from a response body, the
+// regexp's first group or else its whole match, and compares it with
+// capture-min and capture-max. It returns a message when the value leaves
+// its range (alert) or comes back; a check without a number leaves the
+// range state as it was. stats.mu must be held.
+func recordCapture(stats *EndpointStats, cfg EndpointConfig, body []byte, bodyErr error) (message string, alert bool) {
+	if stats.Capture == nil {
+		stats.Capture = &captureStats{History: []capturePoint{}}
+	}
+	c := stats.Capture
+	if bodyErr != nil {
+		c.Error = fmt.Sprintf("reading body: %v", bodyErr)
+		return "", false
+	}
+	m := cfg.captureRe.FindSubmatch(body)
+	if m == nil {
+		c.Error = "no match in body"
+		return "", false
+	}
+	text := strings.TrimSpace(string(m[min(1, len(m)-1)]))
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		c.Error = fmt.Sprintf("captured %q, not a number", truncate(text, 40))
+		return "", false
+	}
+	c.Value, c.Error = &value, ""
+	c.History = keepLast(append(c.History, capturePoint{stats.LastCheck, value}), min(captureHistory, history_max))
+
+	var outside string
+	switch {
+	case cfg.CaptureMin != nil && value < *cfg.CaptureMin:
+		outside = fmt.Sprintf("below capture-min %g", *cfg.CaptureMin)
+	case cfg.CaptureMax != nil && value > *cfg.CaptureMax:
+		outside = fmt.Sprintf("above capture-max %g", *cfg.CaptureMax)
+	}
+	switch {
+	case outside != "" && !c.OutOfRange:
+		message, alert = fmt.Sprintf("%s - captured value %g is %s", stats.ID, value, outside), true
+	case outside == "" && c.OutOfRange:
+		message = fmt.Sprintf("%s - captured value %g is back within range", stats.ID, value)
+	}
+	c.OutOfRange = outside != ""
+	return message, alert
+}
+
+func budgetString(budget time.Duration) string {
+	if budget <= 0 {
+		return ""
+	}
+	return budget.String()
+}
+
+// observedWindow is how many recent check start times the observed
+// interval is averaged over.
+const observedWindow = 10
+
+// recordCheckTime notes when a check started and updates the average time
+// between the starts of recent checks, which includes the time the checks
+// themselves took. stats.mu must be held.
+func recordCheckTime(stats *EndpointStats, start time.Time) {
+	stats.checkTimes = keepLast(append(stats.checkTimes, start), min(observedWindow, history_max))
+	if n := len(stats.checkTimes); n > 1 {
+		average := stats.checkTimes[n-1].Sub(stats.checkTimes[0]) / time.Duration(n-1)
+		stats.ObservedInterval = average.Round(time.Millisecond).String()
+	}
+}
+
+// recordRecent keeps the last -degraded-window outcomes and reports whether
+// the endpoint is up but below the -degraded success rate. stats.mu must be
+// held.
+func recordRecent(stats *EndpointStats, up bool) bool {
+	if degraded_percent <= 0 {
+		return false
+	}
+	stats.recent = append(stats.recent, up)
+	stats.recent = keepLast(stats.recent, degraded_window)
+	successes := 0
+	for _, ok := range stats.recent {
+		if ok {
+			successes++
+		}
+	}
+	rate := float64(successes) / float64(len(stats.recent)) * 100
+	stats.IsDegraded = up && rate < degraded_percent
+	return stats.IsDegraded
+}
+
+// newDialer returns the dialer for checks, connecting from sourceIP if it
+// is set.
+func newDialer(sourceIP net.IP) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   dial_timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	if sourceIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: sourceIP}
+	}
+	return dialer
+}
+
+func newTransport() *http.Transport {
+	return &http.Transport{
+		Proxy:                  http.ProxyFromEnvironment,
+		DialContext:            newDialer(nil).DialContext,
+		TLSHandshakeTimeout:    tls_timeout,
+		ResponseHeaderTimeout:  header_timeout,
+		MaxResponseHeaderBytes: max_header_bytes,
+		ForceAttemptHTTP2:      true,
+		MaxIdleConns:           100,
+		MaxIdleConnsPerHost:    max_idle_conns,
+		IdleConnTimeout:        90 * time.Second,
+	}
+}
+
+// clientFor returns the client an endpoint is checked with. It shares the
+// global transport and connection pool unless cfg needs a transport of its
+// own.
+func clientFor(cfg EndpointConfig) *http.Client {
+	c := *client
+	c.Timeout = cfg.Timeout
+	if cfg.Location != "" || (redirect_policy != "follow" && cfg.HTTPSRedirect == "") {
+		// The redirect itself is what gets checked.
+		c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	}
+	if cfg.DisableKeepAlives || cfg.NoProxy || cfg.SNI != "" || cfg.CertErrors == "warn" || cfg.sourceIP != nil || cfg.Socket != "" {
+		transport := newTransport()
+		transport.DisableKeepAlives = cfg.DisableKeepAlives
+		if cfg.sourceIP != nil {
+			transport.DialContext = newDialer(cfg.sourceIP).DialContext
+		}
+		if cfg.NoProxy {
+			transport.Proxy = nil
+		}
+		if cfg.Socket != "" {
+			dialer := newDialer(nil)
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", cfg.Socket)
+			}
+			transport.Proxy = nil
+		}
+		if cfg.SNI != "" || cfg.CertErrors == "warn" {
+			// With cert-errors=warn, checkSSLCert reports certificate
+			// problems and the check itself goes ahead regardless.
+			transport.TLSClientConfig = &tls.Config{ServerName: cfg.SNI, InsecureSkipVerify: cfg.CertErrors == "warn"}
+		}
+		c.Transport = transport
+	}
+	return &c
+}
+
+var errRedirectLoop = errors.New("redirect loop")
+
+var errTokenRefresh = errors.New("token refresh failed")
+
+// tokenSource fetches and caches an OAuth2 access token using the client
+// credentials grant, refreshing it shortly before it expires.
+type tokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scope        string
+	mu           sync.Mutex
+	token        string
+	expiry       time.Time
+}
+
+// sameCredentials reports whether o asks for a token the same way, so that
+// a reload can keep the token t has cached.
+func (t *tokenSource) sameCredentials(o *tokenSource) bool {
+	return t.tokenURL == o.tokenURL && t.clientID == o.clientID && t.clientSecret == o.clientSecret && t.scope == o.scope
+}
+
+func (t *tokenSource) get(c *http.Client) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Until(t.expiry) > 30*time.Second {
+		return t.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {t.clientID},
+		"client_secret": {t.clientSecret},
+	}
+	if t.scope != "" {
+		form.Set("scope", t.scope)
+	}
+	resp, err := c.PostForm(t.tokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errTokenRefresh, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token endpoint returned %s", errTokenRefresh, resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodyBytes)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: %v", errTokenRefresh, err)
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("%w: no access_token in response", errTokenRefresh)
+	}
+	if body.ExpiresIn <= 0 {
+		body.ExpiresIn = 300
+	}
+	t.token = body.AccessToken
+	t.expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+// invalidate drops the cached token, e.g. after a 401, so the next check
+// fetches a new one.
+func (t *tokenSource) invalidate() {
+	t.mu.Lock()
+	t.token = ""
+	t.mu.Unlock()
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > max_redirects {
+		return errRedirectLoop
+	}
+	return nil
+}
+
+func captureHeaders(h http.Header) map[string]string {
+	captured := make(map[string]string, len(capture_headers))
+	for _, name := range capture_headers {
+		if values := h.Values(name); len(values) > 0 {
+			captured[name] = strings.Join(values, ", ")
+		}
+	}
+	return captured
+}
+
+// Punycode parameters from RFC 3492.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycodeHost converts an internationalized hostname to the ASCII form
+// used on the wire, e.g. müller.example to xn--mller-kva.example. Labels
+// are lowercased but not otherwise normalized, which covers hostnames as
+// they are typed. HTTP requests do this conversion themselves; the cert
+// check dials and verifies by hand, so it needs it here.
+func punycodeHost(host string) string {
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i, label := range labels {
+		if strings.IndexFunc(label, func(r rune) bool { return r >= 0x80 }) >= 0 {
+			labels[i] = "xn--" + punycode(label)
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func punycode(label string) string {
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for handled < len(runes) {
+		next := rune(0x10FFFF)
+		for _, r := range runes {
+			if r >= n && r < next {
+				next = r
+			}
+		}
+		delta += int(next-n) * (handled + 1)
+		n = next
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := min(max(k-bias, punyTMin), punyTMax)
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+func punyAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > (punyBase-punyTMin)*punyTMax/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func checkSSLCert(link string, stats *EndpointStats) {
+	// A few at a time, so that starting thousands of HTTPS endpoints does
+	// not open thousands of TLS connections at once.
+	cert_checks <- struct{}{}
+	defer func() { <-cert_checks }()
+
+	u, err := url.Parse(link)
+	if err != nil {
+		log_printf(Yellow, "%s - SSL cert check failed: %v\n", link, err)
+		return
+	}
+	host := punycodeHost(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	cfg := stats.config()
+	serverName := cmp.Or(cfg.SNI, host)
+
+	// Verification is done by hand below so that hostname and chain problems
+	// can be reported separately instead of failing the dial.
+	conn, err := tls.DialWithDialer(newDialer(cfg.sourceIP), "tcp", net.JoinHostPort(host, port), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		log_printf(Yellow, "%s - SSL cert check failed: %v\n", link, err)
+		return
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) > 0 {
+		expiry := certs[0].NotAfter
+		warnings := verifyCert(link, serverName, certs)
+		if time.Now().After(expiry) {
+			warnings = append(warnings, "expired")
+		}
+		sum := sha256.Sum256(certs[0].Raw)
+		fingerprint := hex.EncodeToString(sum[:])
+		issuer := cmp.Or(certs[0].Issuer.CommonName, certs[0].Issuer.String())
+		stats.mu.Lock()
+		stats.CertExpiry = expiry
+		stats.CertWarnings = warnings
+		oldFingerprint, oldIssuer := stats.CertFingerprint, stats.CertIssuer
+		stats.CertFingerprint, stats.CertIssuer = fingerprint, issuer
+		severity := stats.Severity
+		stats.mu.Unlock()
+
+		// A renewal looks the same as an interception; either way someone
+		// should know.
+		if cfg.CertChange && oldFingerprint != "" && fingerprint != oldFingerprint {
+			message := fmt.Sprintf("%s - SSL cert changed: issuer %q -> %q, SHA-256 fingerprint %s -> %s",
+				stats.ID, oldIssuer, issuer, oldFingerprint, fingerprint)
+			log_printf(Yellow, "%s\n", message)
+			if !inStartupGrace() {
+				dispatch(alertEvent{Kind: "cert_changed", ID: stats.ID, URL: stats.URL, Message: message, Time: time.Now(), Severity: severity})
+			}
+		}
+
+		daysUntilExpiry := int(time.Until(expiry).Hours() / 24)
+		if time.Now().After(expiry) {
+			playAlert()
+			log_printf(Yellow, "%s - SSL cert expired on %s\n", link, expiry.Format("2006-01-02"))
+		} else if daysUntilExpiry <= certWarnDays {
+			playAlert()
+			log_printf(Yellow, "%s - SSL cert expires in %d days (%s)\n", link, daysUntilExpiry, expiry.Format("2006-01-02"))
+		} else if show_ok && len(warnings) == 0 {
+			log_printf(Green, "%s - SSL cert valid for %d days\n", link, daysUntilExpiry)
+		}
+	}
+}
+
+// verifyCert checks that the leaf certificate covers host and that the
+// presented chain verifies against the system roots. Expiry is left to the
+// caller, which reports it on its own.
+func verifyCert(link, host string, certs []*x509.Certificate) []string {
+	var warnings []string
+	leaf := certs[0]
+
+	if err := leaf.VerifyHostname(host); err != nil {
+		playAlert()
+		log_printf(Yellow, "%s - SSL cert hostname mismatch: %v\n", link, err)
+		warnings = append(warnings, "hostname mismatch")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{Intermediates: intermediates})
+	var invalid x509.CertificateInvalidError
+	if err != nil && !(errors.As(err, &invalid) && invalid.Reason == x509.Expired) {
+		playAlert()
+		log_printf(Yellow, "%s - SSL cert chain does not verify: %v\n", link, err)
+		warnings = append(warnings, "untrusted chain")
+	}
+
+	return warnings
+}
+
+// version and commit can be set when building, e.g.
+// go build -ldflags "-X main.version=1.4.0 -X main.commit=3f2c1ab".
+// Otherwise they are taken from the module and VCS information that go
+// build embeds, when available.
+var (
+	version = ""
+	commit  = ""
+)
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if b.Version == "" && info.Main.Version != "(devel)" && info.Main.Version != "" {
+			b.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if b.Commit == "" {
+					b.Commit = setting.Value
+				}
+			case "vcs.modified":
+				b.Modified = setting.Value == "true" && commit == ""
+			}
+		}
+	}
+	if b.Version == "" {
+		b.Version = "dev"
+	}
+	return b
+}
+
+func (b buildInfo) String() string {
+	text := b.Version
+	if b.Commit != "" {
+		text += " (" + b.Commit[:min(len(b.Commit), 12)]
+		if b.Modified {
+			text += ", modified"
+		}
+		text += ")"
+	}
+	return text + " " + b.GoVersion
+}
+
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+type alertEvent struct {
+	Kind     string       `json:"event"`
+	ID       string       `json:"id,omitempty"`
+	URL      string       `json:"url,omitempty"`
+	Severity string       `json:"severity,omitempty"`
+	Message  string       `json:"text"`
+	Time     time.Time    `json:"time"`
+	Events   []alertEvent `json:"events,omitempty"`
+}
+
+// Endpoint severities. Info endpoints are only logged, critical ones are
+// also sent to -critical-webhook and listed first on the dashboard.
+const (
+	severityCritical = "critical"
+	severityWarning  = "warning"
+	severityInfo     = "info"
+)
+
+var severityRank = map[string]int{severityCritical: 0, severityWarning: 1, severityInfo: 2}
+
+type notifier interface {
+	name() string
+	notify(event alertEvent) error
+}
+
+// dispatch hands an event to every notifier in the background so a slow
+// notification target never delays the checks.
+func dispatch(event alertEvent) {
+	if len(notifiers) == 0 || event.Severity == severityInfo {
+		return
+	}
+	if !mutedUntil().IsZero() {
+		log_printf(Yellow, "%s - %s notification not sent: alerts are muted\n", event.ID, event.Kind)
+		return
+	}
+	if alert_digest != nil {
+		alert_digest.add(event)
+		return
+	}
+	go notifyAll(event)
+}
+
+// alertMute silences all notifications and sound alerts, e.g. during a
+// known broad incident. It always ends by itself after at most -mute-max.
+var alertMute struct {
+	mu    sync.Mutex
+	until time.Time
+	timer *time.Timer
+}
+
+// mutedUntil returns when the current mute ends, or the zero time if
+// alerts are not muted.
+func mutedUntil() time.Time {
+	alertMute.mu.Lock()
+	defer alertMute.mu.Unlock()
+	if time.Now().Before(alertMute.until) {
+		return alertMute.until
+	}
+	return time.Time{}
+}
+
+// muteAlerts mutes alerts for d, or lifts the mute when d is 0.
+func muteAlerts(d time.Duration) {
+	alertMute.mu.Lock()
+	if alertMute.timer != nil {
+		alertMute.timer.Stop()
+		alertMute.timer = nil
+	}
+	alertMute.until = time.Time{}
+	if d > 0 {
+		alertMute.until = time.Now().Add(d)
+		alertMute.timer = time.AfterFunc(d, func() {
+			log_print(Yellow, "Alert mute expired, alerts are back on")
+			clearDashboardCache()
+		})
+	}
+	alertMute.mu.Unlock()
+	clearDashboardCache()
+}
+
+func notifyAll(event alertEvent) {
+	for _, n := range notifiers {
+		if err := n.notify(event); err != nil {
+			log_printf(Yellow, "%s - notification failed: %v\n", n.name(), err)
+		}
+	}
+}
+
+// heartbeat logs a one-line aggregate of all endpoint states every
+// interval, as a steady sign that the monitor is alive.
+func heartbeat(interval time.Duration) {
+	for sleep(interval) {
+		counts := make(map[string]int)
+		endpointsMu.RLock()
+		for _, stats := range endpoints {
+			stats.mu.Lock()
+			counts[stats.State]++
+			stats.mu.Unlock()
+		}
+		endpointsMu.RUnlock()
+
+		line := fmt.Sprintf("%d up, %d down", counts[stateUp], counts[stateDown])
+		if counts[stateDegraded] > 0 {
+			line += fmt.Sprintf(", %d degraded", counts[stateDegraded])
+		}
+		if counts[statePending] > 0 {
+			line += fmt.Sprintf(", %d pending", counts[statePending])
+		}
+		color := Green
+		if counts[stateDown] > 0 {
+			color = Red
+		} else if counts[stateDegraded] > 0 {
+			color = Yellow
+		}
+		log_print(color, line)
+	}
+}
+
+// firstRound waits until every endpoint has been checked once, apart from
+// those waiting for their cron= schedule, and returns which ones are up and
+// which are down. It reports false if monitoring stopped first.
+func firstRound() (up, down []string, ok bool) {
+	for {
+		up, down = nil, nil
+		pending := false
+		endpointsMu.RLock()
+		for _, stats := range endpoints {
+			stats.mu.Lock()
+			switch {
+			case stats.State == statePending && stats.Config.cron != nil:
+				// Not due until its scheduled time.
+			case stats.State == statePending:
+				pending = true
+			case stats.State == stateDown:
+				down = append(down, stats.ID)
+			default:
+				up = append(up, stats.ID)
+			}
+			stats.mu.Unlock()
+		}
+		endpointsMu.RUnlock()
+		if !pending {
+			return up, down, true
+		}
+		if !sleep(250 * time.Millisecond) {
+			return nil, nil, false
+		}
+	}
+}
+
+// announceReady waits for the first round of checks and then signals that
+// the monitor is ready: /healthz starts returning 200, -ready-file is
+// written, -ready-line printed and, if notify is set, a one-time "startup"
+// event sent so operators know monitoring is live.
+func announceReady(readyFile string, readyLine, notify bool) {
+	up, down, ok := firstRound()
+	if !ok {
+		return
+	}
+	is_ready.Store(true)
+	if readyFile != "" {
+		if err := os.WriteFile(readyFile, []byte(time.Now().Format(time.RFC3339)+"\n"), 0o644); err != nil {
+			log_printf(Yellow, "Cannot write -ready-file: %v\n", err)
+		}
+	}
+	if readyLine {
+		fmt.Println("UPTIMER_READY")
+	}
+	if notify {
+		message := fmt.Sprintf("Uptimer started: all %d endpoints are up", len(up))
+		if len(down) > 0 {
+			slices.Sort(down)
+			message = fmt.Sprintf("Uptimer started: %d of %d endpoints are up, down: %s",
+				len(up), len(up)+len(down), strings.Join(down, ", "))
+		}
+		notifyAll(alertEvent{Kind: "startup", Message: message, Time: time.Now()})
+	}
+}
+
+// digest is the -digest buffering layer: events are collected and sent as
+// a single "digest" event per interval, together with certificates that
+// started expiring since the last one.
+type digest struct {
+	interval      time.Duration
+	mu            sync.Mutex
+	events        []alertEvent
+	reportedCerts map[string]time.Time
+}
+
+func (d *digest) add(event alertEvent) {
+	d.mu.Lock()
+	d.events = append(d.events, event)
+	d.mu.Unlock()
+}
+
+func (d *digest) run() {
+	for sleep(d.interval) {
+		d.flush()
+	}
+}
+
+// flush sends what has been collected, if anything, and waits for the
+// notifiers so that it can also be used on shutdown.
+func (d *digest) flush() {
+	d.mu.Lock()
+	events := d.events
+	d.events = nil
+	d.mu.Unlock()
+	// Muting silences what was already collected too; expiring
+	// certificates are reported once the mute is over.
+	if !mutedUntil().IsZero() {
+		return
+	}
+
+	endpointsMu.RLock()
+	for _, stats := range endpoints {
+		stats.mu.Lock()
+		expiry := stats.CertExpiry
+		severity := stats.Severity
+		stats.mu.Unlock()
+		if severity == severityInfo || expiry.IsZero() || time.Until(expiry) > certWarnDays*24*time.Hour || d.reportedCerts[stats.ID].Equal(expiry) {
+			continue
+		}
+		d.reportedCerts[stats.ID] = expiry
+		events = append(events, alertEvent{
+			Kind:     "expiring",
+			ID:       stats.ID,
+			URL:      stats.URL,
+			Severity: severity,
+			Message:  fmt.Sprintf("%s SSL cert expires on %s", stats.ID, expiry.Format("2006-01-02")),
+			Time:     time.Now(),
+		})
+	}
+	endpointsMu.RUnlock()
+	if len(events) == 0 {
+		return
+	}
+
+	// A digest with anything critical in it goes to -critical-webhook too.
+	severity := severityWarning
+	counts := make(map[string]int)
+	var lines []string
+	for _, event := range events {
+		if event.Severity == severityCritical {
+			severity = severityCritical
+		}
+		counts[event.Kind]++
+		lines = append(lines, strings.ToUpper(event.Kind)+": "+event.Message)
+	}
+	var totals []string
+	for _, kind := range []string{"down", "recovered", "degraded", "slow", "threshold", "ip_changed", "cert_changed", "expiring"} {
+		if counts[kind] > 0 {
+			totals = append(totals, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	notifyAll(alertEvent{
+		Kind:     "digest",
+		Severity: severity,
+		Message:  fmt.Sprintf("Uptimer digest for the last %v: %s\n%s", d.interval, strings.Join(totals, ", "), strings.Join(lines, "\n")),
+		Time:     time.Now(),
+		Events:   events,
+	})
+}
+
+// testNotifiers sends a sample alert through every notifier and reports
+// whether all of them accepted it.
+func testNotifiers() bool {
+	if len(notifiers) == 0 {
+		color_print(Yellow, "No notifiers configured (see -webhook)")
+		return false
+	}
+	event := alertEvent{Kind: "test", ID: "https://example.com", URL: "https://example.com", Message: "Uptimer test alert - notifications are working", Time: time.Now()}
+	ok := true
+	for _, n := range notifiers {
+		if err := n.notify(event); err != nil {
+			ok = false
+			color_printf(Red, "%s - FAILED: %v\n", n.name(), err)
+		} else {
+			color_printf(Green, "%s - OK\n", n.name())
+		}
+	}
+	return ok
+}
+
+// webhookNotifier POSTs the event as JSON. The message is in the "text"
+// field, which Slack and Mattermost incoming webhooks display as-is. With
+// a body template (-custom-webhook) the event is rendered through it
+// instead, for services that expect a JSON shape of their own.
+type webhookNotifier struct {
+	url          string
+	criticalOnly bool
+	body         *template.Template
+}
+
+func (n *webhookNotifier) name() string { return "webhook " + n.url }
+
+func (n *webhookNotifier) notify(event alertEvent) error {
+	if n.criticalOnly && event.Severity != severityCritical && event.Kind != "test" {
+		return nil
+	}
+	var payload []byte
+	if n.body != nil {
+		var buf bytes.Buffer
+		if err := n.body.Execute(&buf, event); err != nil {
+			return err
+		}
+		payload = buf.Bytes()
+	} else {
+		var err error
+		if payload, err = json.Marshal(event); err != nil {
+			return err
+		}
+	}
+	resp, err := notify_client.Post(n.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// splitCustomWebhook splits a -custom-webhook value into the URL and the
+// template file. A URL cannot contain unescaped whitespace, so the file
+// starts after the first space and may have spaces of its own, as in
+// C:\Program Files\uptimer\alert.tmpl.
+func splitCustomWebhook(value string) (target, path string, ok bool) {
+	value = strings.TrimSpace(value)
+	i := strings.IndexAny(value, " \t")
+	if i < 0 {
+		return "", "", false
+	}
+	return value[:i], strings.TrimSpace(value[i+1:]), true
+}
+
+// parseWebhookTemplate reads a -custom-webhook body template. The alert
+// event is available as .Kind, .ID, .URL, .Severity, .Message, .Time and
+// .Events, and {{json .Message}} quotes a value as a JSON string. It is
+// rendered once with a sample event, so mistakes show up at startup.
+func parseWebhookTemplate(path string) (*template.Template, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+	sample := alertEvent{
+		Kind:     "down",
+		ID:       "https://example.com",
+		URL:      "https://example.com",
+		Severity: severityWarning,
+		Message:  `https://example.com HAS RETURNED 500 Internal Server Error INSTEAD OF 200 - "POSSIBLE DOWN!!"`,
+		Time:     time.Now(),
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, sample); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		color_printf(Yellow, "Warning: %s does not render valid JSON for a sample alert; use {{json .Message}} to quote values\n", path)
+	}
+	return tmpl, nil
 }
 
 func increaseBackoff(current time.Duration) time.Duration {
@@ -257,10 +4084,34 @@ func increaseBackoff(current time.Duration) time.Duration {
 	return next
 }
 
+var (
+	beepInit    sync.Once
+	beepFailure sync.Once
+	beepProc    *syscall.LazyProc
+)
+
+// playAlert beeps when -sa is set. Beep is looked up once; if it is missing
+// (e.g. on Server Core) or fails, a single warning is logged instead of
+// panicking in the check loop.
 func playAlert() {
-	if sound_alert {
-		beep := syscall.NewLazyDLL("kernel32.dll").NewProc("Beep")
-		beep.Call(750, 300)
+	if !sound_alert || !mutedUntil().IsZero() {
+		return
+	}
+	beepInit.Do(func() {
+		proc := syscall.NewLazyDLL("kernel32.dll").NewProc("Beep")
+		if err := proc.Find(); err != nil {
+			log_printf(Yellow, "Sound alert unavailable, continuing without it: %v\n", err)
+			return
+		}
+		beepProc = proc
+	})
+	if beepProc == nil {
+		return
+	}
+	if ok, _, err := beepProc.Call(750, 300); ok == 0 {
+		beepFailure.Do(func() {
+			log_printf(Yellow, "Sound alert could not be played: %v\n", err)
+		})
 	}
 }
 
@@ -281,28 +4132,310 @@ func printShutdownSummary() {
 	uptime := time.Since(startTime).Round(time.Second)
 	fmt.Printf("Total uptime: %v\n\n", uptime)
 
-	endpointsMu.RLock()
-	defer endpointsMu.RUnlock()
-
-	for _, stats := range endpoints {
+	report := summaryReport{
+		StartTime: startTime,
+		EndTime:   time.Now(),
+		Uptime:    uptime.String(),
+	}
+	for _, stats := range orderedEndpoints() {
 		stats.mu.Lock()
-		uptimePercent := float64(0)
-		if stats.TotalChecks > 0 {
-			uptimePercent = float64(stats.SuccessfulChecks) / float64(stats.TotalChecks) * 100
+		uptimePercent := stats.uptimePercent()
+		var latency *latencyStats
+		if stats.Latency != nil {
+			copied := *stats.Latency
+			latency = &copied
 		}
+		var lifetime *lifetimeStats
+		if summary_file != "" {
+			lifetime = lifetimeOf(stats)
+		}
+		report.Endpoints = append(report.Endpoints, endpointSummary{
+			ID:               stats.ID,
+			URL:              stats.URL,
+			Name:             stats.Name,
+			IsUp:             stats.IsUp,
+			IsDegraded:       stats.IsDegraded,
+			State:            stats.State,
+			UptimePercent:    uptimePercent,
+			TotalChecks:      stats.TotalChecks,
+			SuccessfulChecks: stats.SuccessfulChecks,
+			ConsecFailures:   stats.ConsecFailures,
+			Incidents:        stats.Incidents,
+			LongestOutage:    stats.LongestOutage,
+			CertExpiry:       stats.CertExpiry,
+			Latency:          latency,
+			Lifetime:         lifetime,
+		})
 		status := Green + "UP" + Reset
-		if !stats.IsUp {
+		switch stats.State {
+		case statePending:
+			status = "PENDING (never checked)"
+		case stateDown:
 			status = Red + "DOWN" + Reset
+		case stateDegraded:
+			status = Yellow + "DEGRADED" + Reset
+		}
+		if stats.Name != "" {
+			fmt.Printf("%s (%s)\n", stats.Name, stats.ID)
+		} else {
+			fmt.Printf("%s\n", stats.ID)
 		}
-		fmt.Printf("%s\n", stats.URL)
 		fmt.Printf("  Status: %s | Uptime: %.2f%% | Checks: %d/%d | Consec Failures: %d\n",
 			status, uptimePercent, stats.SuccessfulChecks, stats.TotalChecks, stats.ConsecFailures)
+		// Compared with the runs before this one, so a change shows even
+		// when this run is a small part of the total.
+		if lifetime != nil && lifetime.Runs > 1 {
+			fmt.Printf("  All Runs: Uptime: %.2f%% | Checks: %d/%d | %d runs since %s",
+				lifetime.UptimePercent, lifetime.SuccessfulChecks, lifetime.TotalChecks, lifetime.Runs, lifetime.Since.Format("2006-01-02"))
+			if stats.TotalChecks > 0 && lifetime.TotalChecks > stats.TotalChecks {
+				delta := uptimePercent - lifetime.previousPercent
+				color := Green
+				if delta < 0 {
+					color = Red
+				}
+				fmt.Printf(" | This Run: %s%+.2f points%s", color, delta, Reset)
+			}
+			fmt.Println()
+		}
+		if stats.LongestOutage != "" {
+			fmt.Printf("  Incidents: %d | Longest Outage: %s\n", stats.Incidents, stats.LongestOutage)
+		}
+		if stats.Latency != nil {
+			fmt.Printf("  Latency: %.2f%% of checks within %s (%d of %d over budget)\n",
+				stats.Latency.FastPercent, stats.Latency.Budget, stats.Latency.OverBudget, stats.Latency.Checks)
+		}
 		if !stats.CertExpiry.IsZero() {
 			fmt.Printf("  SSL Cert Expires: %s\n", stats.CertExpiry.Format("2006-01-02"))
 		}
 		stats.mu.Unlock()
 	}
 	fmt.Println(Yellow + "======================================" + Reset)
+
+	if summary_file != "" {
+		listed := make(map[string]bool, len(report.Endpoints))
+		for _, e := range report.Endpoints {
+			listed[e.ID] = true
+		}
+		report.Absent = retainedEndpoints(listed, report.EndTime)
+		if err := writeSummaryFile(summary_file, report); err != nil {
+			color_printf(Red, "Error writing summary to %s: %v\n", summary_file, err)
+		} else {
+			color_printf(Green, "Summary written to %s\n", summary_file)
+		}
+	}
+}
+
+type summaryReport struct {
+	StartTime time.Time         `json:"start_time"`
+	EndTime   time.Time         `json:"end_time"`
+	Uptime    string            `json:"uptime"`
+	Endpoints []endpointSummary `json:"endpoints"`
+	Absent    []pastEndpoint    `json:"absent,omitempty"`
+}
+
+type endpointSummary struct {
+	ID               string         `json:"id"`
+	URL              string         `json:"url"`
+	Name             string         `json:"name,omitempty"`
+	IsUp             bool           `json:"is_up"`
+	IsDegraded       bool           `json:"is_degraded"`
+	State            string         `json:"state"`
+	UptimePercent    float64        `json:"uptime_percent"`
+	TotalChecks      int64          `json:"total_checks"`
+	SuccessfulChecks int64          `json:"successful_checks"`
+	ConsecFailures   int            `json:"consecutive_failures"`
+	Incidents        int            `json:"incidents"`
+	LongestOutage    string         `json:"longest_outage,omitempty"`
+	CertExpiry       time.Time      `json:"cert_expiry,omitzero"`
+	Latency          *latencyStats  `json:"latency,omitempty"`
+	Lifetime         *lifetimeStats `json:"lifetime,omitempty"`
+}
+
+// lifetimeStats adds up an endpoint's checks over all the runs that
+// wrote the same -summary-file.
+type lifetimeStats struct {
+	Since            time.Time `json:"since"`
+	Runs             int       `json:"runs"`
+	UptimePercent    float64   `json:"uptime_percent"`
+	TotalChecks      int64     `json:"total_checks"`
+	SuccessfulChecks int64     `json:"successful_checks"`
+	previousPercent  float64   // before this run
+}
+
+// pastEndpoint is an endpoint of an earlier run, or one a reload removed
+// during this run (thisRun). Endpoints no longer listed keep their totals
+// in the summary file for -summary-retention, so that commenting one out
+// for a while does not reset them.
+type pastEndpoint struct {
+	ID       string         `json:"id"`
+	URL      string         `json:"url"`
+	Name     string         `json:"name,omitempty"`
+	LastSeen time.Time      `json:"last_seen"`
+	Lifetime *lifetimeStats `json:"lifetime"`
+	thisRun  bool           // Lifetime already counts this run
+}
+
+// readSummaryFile loads the endpoints of the summary a previous run wrote,
+// both those it monitored and those it kept as absent.
+// A missing file is not an error: there is nothing to compare with yet.
+func readSummaryFile(path string) (map[string]pastEndpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report summaryReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	previous := make(map[string]pastEndpoint, len(report.Endpoints)+len(report.Absent))
+	for _, e := range report.Absent {
+		if e.Lifetime != nil {
+			previous[e.ID] = e
+		}
+	}
+	for _, e := range report.Endpoints {
+		// Summaries written before lifetime totals existed count as one run.
+		if e.Lifetime == nil {
+			e.Lifetime = &lifetimeStats{Since: report.StartTime, Runs: 1, TotalChecks: e.TotalChecks, SuccessfulChecks: e.SuccessfulChecks}
+		}
+		previous[e.ID] = pastEndpoint{ID: e.ID, URL: e.URL, Name: e.Name, LastSeen: report.EndTime, Lifetime: e.Lifetime}
+	}
+	return previous, nil
+}
+
+// retainedEndpoints lists the endpoints that are not listed now and were
+// last seen less than -summary-retention before now, sorted by ID. With a
+// retention of 0 there are none.
+func retainedEndpoints(listed map[string]bool, now time.Time) []pastEndpoint {
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	var retained []pastEndpoint
+	for id, e := range previous_summary {
+		if listed[id] || now.Sub(e.LastSeen) >= summary_retention {
+			continue
+		}
+		retained = append(retained, e)
+	}
+	slices.SortFunc(retained, func(a, b pastEndpoint) int { return strings.Compare(a.ID, b.ID) })
+	return retained
+}
+
+// retainRemoved keeps the totals of an endpoint a reload removed, with
+// this run's checks, as if it had been absent from this run.
+// stats.mu must be held.
+func retainRemoved(stats *EndpointStats) {
+	life := lifetimeOf(stats)
+	summaryMu.Lock()
+	if previous_summary == nil {
+		previous_summary = make(map[string]pastEndpoint)
+	}
+	previous_summary[stats.ID] = pastEndpoint{ID: stats.ID, URL: stats.URL, Name: stats.Name, LastSeen: time.Now(), Lifetime: life, thisRun: true}
+	summaryMu.Unlock()
+}
+
+// lifetimeOf adds this run's checks to the endpoint's totals from the
+// previous summary. stats.mu must be held.
+func lifetimeOf(stats *EndpointStats) *lifetimeStats {
+	life := lifetimeStats{Since: startTime}
+	summaryMu.Lock()
+	previous, ok := previous_summary[stats.ID]
+	summaryMu.Unlock()
+	if ok {
+		life = *previous.Lifetime
+		if life.TotalChecks > 0 {
+			life.previousPercent = float64(life.SuccessfulChecks) / float64(life.TotalChecks) * 100
+		}
+	}
+	// Removed and listed again by a reload, it was counted on removal.
+	if !previous.thisRun {
+		life.Runs++
+	}
+	life.TotalChecks += stats.TotalChecks
+	life.SuccessfulChecks += stats.SuccessfulChecks
+	if life.TotalChecks > 0 {
+		life.UptimePercent = float64(life.SuccessfulChecks) / float64(life.TotalChecks) * 100
+	}
+	return &life
+}
+
+// metricsLog is the -metrics-log file: one JSON object per check, appended
+// through a buffer that is flushed periodically and on shutdown.
+type metricsLog struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+type metricsRecord struct {
+	Time           time.Time `json:"timestamp"`
+	ID             string    `json:"id"`
+	URL            string    `json:"url"`
+	Code           string    `json:"code"`
+	ResponseTimeMs int64     `json:"response_time_ms"`
+	Up             bool      `json:"up"`
+}
+
+func openMetricsLog(path string) (*metricsLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsLog{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// record appends the check that just completed on stats; the caller holds
+// stats.mu. A nil log (no -metrics-log) records nothing.
+func (l *metricsLog) record(stats *EndpointStats, up bool) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(metricsRecord{
+		Time:           stats.LastCheck,
+		ID:             stats.ID,
+		URL:            stats.URL,
+		Code:           stats.LastStatus,
+		ResponseTimeMs: stats.LastResponseTime,
+		Up:             up,
+	})
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(line, '\n'))
+}
+
+func (l *metricsLog) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		log_printf(Yellow, "Warning: writing metrics log: %v\n", err)
+	}
+}
+
+func (l *metricsLog) flushEvery(interval time.Duration) {
+	for sleep(interval) {
+		l.flush()
+	}
+}
+
+func (l *metricsLog) close() {
+	if l == nil {
+		return
+	}
+	l.flush()
+	l.file.Close()
+}
+
+func writeSummaryFile(path string, report summaryReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
 }
 
 func timestamp() string {
@@ -328,45 +4461,150 @@ func color_printf(color, format string, a ...any) {
 func startDashboard(port string) {
 	http.HandleFunc("/", dashboardHandler)
 	http.HandleFunc("/api/status", apiStatusHandler)
+	http.HandleFunc("/api/status.txt", apiStatusTextHandler)
+	http.HandleFunc("/api/daily", apiDailyHandler)
+	http.HandleFunc("/api/reload", apiReloadHandler)
+	http.HandleFunc("/api/mute", apiMuteHandler)
+	http.HandleFunc("/api/unmute", apiUnmuteHandler)
+	http.HandleFunc("/api/version", apiVersionHandler)
+	http.HandleFunc("/api/config", apiConfigHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/badge", badgeHandler)
+	http.HandleFunc("/healthz", healthzHandler)
 	http.ListenAndServe(":"+port, nil)
 }
 
+type dashboardTheme struct {
+	good, bad, warn                string
+	lightGood, lightBad, lightWarn string
+	upText, downText               string
+}
+
+// themes are selected with -theme or ?theme=. The colorblind palette uses
+// blue/orange and adds symbols so status does not depend on color alone.
+// The light variants are darker so they stay readable on a white page.
+var themes = map[string]dashboardTheme{
+	"default": {good: "#00ff88", bad: "#ff4444", warn: "#ffaa00",
+		lightGood: "#007a3d", lightBad: "#cc0000", lightWarn: "#a35f00",
+		upText: "UP", downText: "DOWN"},
+	"colorblind": {good: "#3399ff", bad: "#ff8800", warn: "#ffdd55",
+		lightGood: "#0059b3", lightBad: "#c25e00", lightWarn: "#806600",
+		upText: "&#10003; UP", downText: "&#10007; DOWN"},
+}
+
+// dashboardCacheTTL is how long a rendered dashboard is reused, so that
+// any number of viewers costs at most one render per theme and interval.
+const dashboardCacheTTL = time.Second
+
+var dashboardCache = struct {
+	mu    sync.Mutex
+	pages map[string]cachedPage
+}{pages: make(map[string]cachedPage)}
+
+// clearDashboardCache drops the rendered pages, so a change such as a mute
+// shows on the next request.
+func clearDashboardCache() {
+	dashboardCache.mu.Lock()
+	clear(dashboardCache.pages)
+	dashboardCache.mu.Unlock()
+}
+
+type cachedPage struct {
+	body     []byte
+	rendered time.Time
+}
+
 func dashboardHandler(w http.ResponseWriter, r *http.Request) {
-	html := `<!DOCTYPE html>
+	themeName := r.URL.Query().Get("theme")
+	if _, ok := themes[themeName]; !ok {
+		themeName = dashboard_theme
+	}
+
+	// Held while rendering, so concurrent viewers wait for one render
+	// instead of each starting their own.
+	dashboardCache.mu.Lock()
+	cached, ok := dashboardCache.pages[themeName]
+	if !ok || time.Since(cached.rendered) > dashboardCacheTTL {
+		var buf bytes.Buffer
+		renderDashboard(&buf, themes[themeName])
+		cached = cachedPage{body: buf.Bytes(), rendered: time.Now()}
+		dashboardCache.pages[themeName] = cached
+	}
+	dashboardCache.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(cached.body)
+}
+
+func renderDashboard(w io.Writer, theme dashboardTheme) {
+	page := `<!DOCTYPE html>
 <html>
 <head>
-	<title>Uptimer Dashboard</title>
+	<title>%s</title>
 	<meta http-equiv="refresh" content="5">
+	<script>
+		// Applied before the page is drawn, so a refresh does not flash dark.
+		if (localStorage.getItem("uptimer-mode") === "light") {
+			document.documentElement.dataset.mode = "light";
+		}
+		function toggleMode() {
+			var light = document.documentElement.dataset.mode !== "light";
+			document.documentElement.dataset.mode = light ? "light" : "dark";
+			localStorage.setItem("uptimer-mode", light ? "light" : "dark");
+		}
+		function post(path) {
+			fetch(path, { method: "POST" }).then(function () { location.reload(); });
+		}
+	</script>
 	<style>
-		body { font-family: Arial, sans-serif; margin: 20px; background: #1a1a2e; color: #eee; }
-		h1 { color: #00d4ff; }
+		:root { --bg: #1a1a2e; --row: #16213e; --text: #eee; --title: #00d4ff; --sub: #aaa; --border: #444; --muted: #888;
+			--good: %s; --bad: %s; --warn: %s; }
+		:root[data-mode="light"] { --bg: #ffffff; --row: #f0f2f7; --text: #222; --title: #0077aa; --sub: #555; --border: #ccc; --muted: #666;
+			--good: %s; --bad: %s; --warn: %s; }
+		body { font-family: Arial, sans-serif; margin: 20px; background: var(--bg); color: var(--text); }
+		a { color: var(--title); }
+		h1 { color: var(--title); }
+		h2 { color: var(--sub); font-weight: normal; margin-top: -10px; }
 		table { border-collapse: collapse; width: 100%%; margin-top: 20px; }
-		th, td { border: 1px solid #444; padding: 12px; text-align: left; }
-		th { background: #16213e; }
-		tr:nth-child(even) { background: #1a1a2e; }
-		tr:nth-child(odd) { background: #16213e; }
-		.up { color: #00ff88; font-weight: bold; }
-		.down { color: #ff4444; font-weight: bold; }
-		.warn { color: #ffaa00; }
-		.uptime-good { color: #00ff88; }
-		.uptime-warn { color: #ffaa00; }
-		.uptime-bad { color: #ff4444; }
+		th, td { border: 1px solid var(--border); padding: 12px; text-align: left; }
+		th { background: var(--row); }
+		tr:nth-child(even) { background: var(--bg); }
+		tr:nth-child(odd) { background: var(--row); }
+		#muted { background: var(--warn); color: #000; padding: 12px; font-weight: bold; margin-bottom: 10px; }
+		#mute { float: right; margin-right: 8px; background: var(--row); color: var(--text); border: 1px solid var(--border); padding: 6px 12px; cursor: pointer; }
+		#mode { float: right; background: var(--row); color: var(--text); border: 1px solid var(--border); padding: 6px 12px; cursor: pointer; }
+		#mode::after { content: "Light mode"; }
+		:root[data-mode="light"] #mode::after { content: "Dark mode"; }
+		.up { color: var(--good); font-weight: bold; }
+		.down { color: var(--bad); font-weight: bold; }
+		.warn { color: var(--warn); }
+		.pending { color: var(--muted); font-weight: bold; }
+		.critical { color: var(--bad); text-transform: uppercase; }
+		.info { color: var(--muted); text-transform: uppercase; }
+		.uptime-good { color: var(--good); }
+		.uptime-warn { color: var(--warn); }
+		.uptime-bad { color: var(--bad); }
 	</style>
 </head>
 <body>
-	<h1>Uptimer Dashboard</h1>
+	%s
+	<button id="mode" onclick="toggleMode()"></button>
+	<h1>%s</h1>
+	%s
 	<p>Monitoring since: %s | Uptime: %s</p>
 	<table>
 		<tr>
 			<th>Endpoint</th>
 			<th>Status</th>
 			<th>Last Code</th>
+			<th>Reason</th>
 			<th>Response Time</th>
 			<th>Uptime</th>
 			<th>Checks</th>
 			<th>Failures</th>
 			<th>SSL Expiry</th>
 			<th>Last Check</th>
+			<th>Last Success</th>
 		</tr>
 		%s
 	</table>
@@ -375,20 +4613,40 @@ func dashboardHandler(w http.ResponseWriter, r *http.Request) {
 </html>`
 
 	var rows string
-	endpointsMu.RLock()
-	for _, stats := range endpoints {
+	for _, stats := range sortedEndpoints() {
 		stats.mu.Lock()
 		statusClass := "up"
-		statusText := "UP"
-		if !stats.IsUp {
+		statusText := theme.upText
+		switch stats.State {
+		case statePending:
+			statusClass = "pending"
+			statusText = "PENDING"
+		case stateDown:
 			statusClass = "down"
-			statusText = "DOWN"
+			statusText = theme.downText
+		case stateDegraded:
+			statusClass = "warn"
+			statusText = "DEGRADED"
 		}
-
-		uptimePercent := float64(0)
-		if stats.TotalChecks > 0 {
-			uptimePercent = float64(stats.SuccessfulChecks) / float64(stats.TotalChecks) * 100
+		if stats.Completed {
+			statusText += "<br><small>COMPLETED</small>"
+		}
+		if c := stats.Capture; c != nil && c.Value != nil {
+			valueClass := ""
+			if c.OutOfRange {
+				valueClass = ` class="warn"`
+			}
+			statusText += fmt.Sprintf("<br><small%s>value %g</small>", valueClass, *c.Value)
+		}
+		for _, port := range stats.PortChecks {
+			if port.IsUp {
+				statusText += fmt.Sprintf("<br><small>%s %s</small>", html.EscapeString(port.Port), port.Status)
+			} else {
+				statusText += fmt.Sprintf(`<br><small class="warn">%s %s</small>`, html.EscapeString(port.Port), html.EscapeString(port.Status))
+			}
 		}
+
+		uptimePercent := stats.uptimePercent()
 		uptimeClass := "uptime-good"
 		if uptimePercent < 99 {
 			uptimeClass = "uptime-warn"
@@ -406,54 +4664,490 @@ func dashboardHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			certExpiry = fmt.Sprintf("<span %s>%s (%dd)</span>", certClass, stats.CertExpiry.Format("2006-01-02"), daysLeft)
 		}
+		for _, warning := range stats.CertWarnings {
+			certExpiry += fmt.Sprintf(`<br><span class="warn">%s</span>`, warning)
+		}
 
 		lastCheck := "-"
 		if !stats.LastCheck.IsZero() {
 			lastCheck = stats.LastCheck.Format("15:04:05")
 		}
 
+		// During an outage show how long ago the endpoint was last healthy.
+		lastSuccess := "-"
+		if !stats.LastSuccess.IsZero() {
+			lastSuccess = stats.LastSuccess.Format("15:04:05")
+			if !stats.IsUp {
+				lastSuccess = fmt.Sprintf(`<span class="warn">%s (%s ago)</span>`,
+					stats.LastSuccess.Format("2006-01-02 15:04:05"), time.Since(stats.LastSuccess).Round(time.Second))
+			}
+		}
+
+		lastStatus := strings.TrimSpace(stats.LastStatus + " " + stats.LastStatusText)
+		reason := "-"
+		if stats.LastError != "" {
+			reason = fmt.Sprintf(`<span title="%s">%s</span>`,
+				html.EscapeString(stats.LastError), html.EscapeString(truncate(stats.LastError, 120)))
+		}
+		timings := fmt.Sprintf("DNS %dms, connect %dms, TLS %dms, first byte %dms",
+			stats.LastTimings.DNS, stats.LastTimings.Connect, stats.LastTimings.TLS, stats.LastTimings.TTFB)
+
+		endpointCell := html.EscapeString(stats.ID)
+		if stats.Name != "" {
+			endpointCell = fmt.Sprintf(`<span title="%[2]s">%[1]s</span><br><small>%[2]s</small>`,
+				html.EscapeString(stats.Name), html.EscapeString(stats.ID))
+		}
+		if stats.Severity != severityWarning {
+			endpointCell += fmt.Sprintf(` <small class="%[1]s">%[1]s</small>`, stats.Severity)
+		}
+
+		latency := ""
+		if stats.Latency != nil {
+			latency = fmt.Sprintf("<br><small>%.2f%% within %s</small>", stats.Latency.FastPercent, stats.Latency.Budget)
+		}
+		if stats.Baseline != nil && stats.Baseline.Slow {
+			latency += fmt.Sprintf(`<br><small class="warn">SLOW (usually %.0fms)</small>`, stats.Baseline.MeanMs)
+		}
+
 		rows += fmt.Sprintf(`<tr>
 			<td>%s</td>
 			<td class="%s">%s</td>
 			<td>%s (expect %s)</td>
-			<td>%dms</td>
+			<td>%s</td>
+			<td title="%s">%v%s</td>
 			<td class="%s">%.2f%%</td>
 			<td>%d</td>
 			<td>%d</td>
 			<td>%s</td>
 			<td>%s</td>
+			<td>%s</td>
 		</tr>`,
-			stats.URL, statusClass, statusText, stats.LastStatus, stats.ExpectedCode,
-			stats.LastResponseTime, uptimeClass, uptimePercent, stats.TotalChecks,
-			stats.ConsecFailures, certExpiry, lastCheck)
+			endpointCell, statusClass, statusText, lastStatus, stats.ExpectedCode, reason,
+			timings, stats.responseTime.Round(rt_precision), latency, uptimeClass, uptimePercent, stats.TotalChecks,
+			stats.ConsecFailures, certExpiry, lastCheck, lastSuccess)
 		stats.mu.Unlock()
 	}
-	endpointsMu.RUnlock()
 
 	uptime := time.Since(startTime).Round(time.Second)
-	fmt.Fprintf(w, html, startTime.Format("2006-01-02 15:04:05"), uptime, rows)
+	title := html.EscapeString(dashboard_title)
+	subtitle := ""
+	if dashboard_sub != "" {
+		subtitle = "<h2>" + html.EscapeString(dashboard_sub) + "</h2>"
+	}
+	fmt.Fprintf(w, page, title, theme.good, theme.bad, theme.warn,
+		theme.lightGood, theme.lightBad, theme.lightWarn, muteControls(), title, subtitle, startTime.Format("2006-01-02 15:04:05"), uptime, rows)
+}
+
+// muteControls is the dashboard's mute button, or while alerts are muted
+// a banner saying until when, so nobody forgets the mute is on.
+func muteControls() string {
+	until := mutedUntil()
+	if until.IsZero() {
+		return fmt.Sprintf(`<button id="mute" onclick="post('/api/mute')" title="Silence all notifications for up to %s">Mute alerts</button>`, mute_max)
+	}
+	return fmt.Sprintf(`<div id="muted">Alerts are MUTED until %s (%s left); they come back on by themselves. <button onclick="post('/api/unmute')">Unmute</button></div>`,
+		until.Format("2006-01-02 15:04:05"), time.Until(until).Round(time.Second))
+}
+
+// apiStatusTextHandler serves the dashboard table as aligned plain text
+// for curl, watch or a tmux status bar.
+func apiStatusTextHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENDPOINT\tSTATUS\tCODE\tTIME\tUPTIME\tCHECKS\tFAILURES\tLAST CHECK")
+	for _, stats := range sortedEndpoints() {
+		stats.mu.Lock()
+		label := stats.ID
+		if stats.Name != "" {
+			label = stats.Name
+		}
+		lastCheck := "-"
+		if !stats.LastCheck.IsZero() {
+			lastCheck = stats.LastCheck.Format("15:04:05")
+		}
+		lastStatus := stats.LastStatus
+		if lastStatus == "" {
+			lastStatus = "-"
+		}
+		state := strings.ToUpper(stats.State)
+		if stats.Completed {
+			state += " (completed)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%.2f%%\t%d\t%d\t%s\n",
+			label, state, lastStatus, stats.responseTime.Round(rt_precision),
+			stats.uptimePercent(), stats.TotalChecks, stats.ConsecFailures, lastCheck)
+		stats.mu.Unlock()
+	}
+	tw.Flush()
 }
 
 func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	// Each endpoint is encoded while its lock is held, as checks update
+	// the slices and pointers inside it in place.
+	statsList := []json.RawMessage{}
+	listed := make(map[string]bool)
+	for _, stats := range orderedEndpoints() {
+		listed[stats.ID] = true
+		stats.mu.Lock()
+		data, err := json.Marshal(stats)
+		stats.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		statsList = append(statsList, data)
+	}
+
+	response := struct {
+		StartTime  string            `json:"start_time"`
+		Uptime     string            `json:"uptime"`
+		MutedUntil time.Time         `json:"muted_until,omitzero"`
+		Endpoints  []json.RawMessage `json:"endpoints"`
+		Absent     []pastEndpoint    `json:"absent,omitempty"`
+	}{
+		StartTime:  startTime.Format(time.RFC3339),
+		Uptime:     time.Since(startTime).Round(time.Second).String(),
+		MutedUntil: mutedUntil(),
+		Endpoints:  statsList,
+		Absent:     retainedEndpoints(listed, time.Now()),
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// apiMuteHandler mutes all alerts for ?for=D, or -mute-max if that is not
+// given or longer.
+func apiMuteHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowChange(w, r) {
+		return
+	}
+	d := mute_max
+	if value := r.URL.Query().Get("for"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "for must be a positive duration, e.g. 30m", http.StatusBadRequest)
+			return
+		}
+		d = min(parsed, mute_max)
+	}
+	muteAlerts(d)
+	log_printf(Yellow, "Alerts muted until %s (by %s)\n", time.Now().Add(d).Format("2006-01-02 15:04:05"), r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiUnmuteHandler ends a mute early.
+func apiUnmuteHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowChange(w, r) {
+		return
+	}
+	if !mutedUntil().IsZero() {
+		muteAlerts(0)
+		log_printf(Green, "Alerts unmuted (by %s)\n", r.RemoteAddr)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowChange accepts a request to one of the API endpoints that change
+// state: a POST that does not come from another site's page, which could
+// otherwise mute alerts from any browser that can reach the dashboard.
+// Browsers send Origin, or at least Referer, with such a POST; curl and
+// scripts send neither and are accepted.
+func allowChange(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return false
+	}
+	source := cmp.Or(r.Header.Get("Origin"), r.Header.Get("Referer"))
+	if source == "" {
+		return true
+	}
+	if u, err := url.Parse(source); err != nil || u.Host != r.Host {
+		log_printf(Yellow, "Refused cross-origin %s from %s (origin %s)\n", r.URL.Path, r.RemoteAddr, source)
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// apiReloadHandler triggers a config reload, for platforms without SIGHUP
+// such as Windows.
+func apiReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowChange(w, r) {
+		return
+	}
+	reloadEndpoints()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// healthzHandler is a readiness probe: 503 until every endpoint has been
+// checked once, 200 afterwards. It says nothing about the endpoints' state.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if !is_ready.Load() {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+// metricsHandler serves the endpoint counters in the Prometheus text format.
+// Scrapers asking for OpenMetrics also get an exemplar on the failure
+// counter with the number and start time of the endpoint's latest incident,
+// so Grafana can jump from a spike in failures to the outage behind it.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+
+	type endpointMetrics struct {
+		id               string
+		checked, up      bool
+		checks, failures int64
+		incidents        int
+		lastIncident     time.Time
+		longestOutage    time.Duration
+		responseTime     time.Duration
+		captured         *float64
+	}
+	var endpoints []endpointMetrics
+	for _, stats := range orderedEndpoints() {
+		stats.mu.Lock()
+		endpoints = append(endpoints, endpointMetrics{
+			id:            stats.ID,
+			checked:       stats.TotalChecks > 0,
+			up:            stats.IsUp,
+			checks:        stats.TotalChecks,
+			failures:      stats.TotalChecks - stats.SuccessfulChecks,
+			incidents:     stats.Incidents,
+			lastIncident:  stats.LastIncident,
+			longestOutage: stats.longestOutage,
+			responseTime:  stats.responseTime,
+		})
+		if stats.Capture != nil {
+			endpoints[len(endpoints)-1].captured = stats.Capture.Value
+		}
+		stats.mu.Unlock()
+	}
+
+	var buf bytes.Buffer
+	family := func(name, kind, help string) {
+		// OpenMetrics names counter families without the _total suffix.
+		if openMetrics && kind == "counter" {
+			name = strings.TrimSuffix(name, "_total")
+		}
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	label := func(id string) string {
+		return `{endpoint="` + metricsLabelEscaper.Replace(id) + `"}`
+	}
+
+	family("uptimer_up", "gauge", "Whether the endpoint passed its last check.")
+	for _, m := range endpoints {
+		if m.checked {
+			up := 0
+			if m.up {
+				up = 1
+			}
+			fmt.Fprintf(&buf, "uptimer_up%s %d\n", label(m.id), up)
+		}
+	}
+	family("uptimer_checks_total", "counter", "Checks performed.")
+	for _, m := range endpoints {
+		fmt.Fprintf(&buf, "uptimer_checks_total%s %d\n", label(m.id), m.checks)
+	}
+	family("uptimer_failures_total", "counter", "Failed checks.")
+	for _, m := range endpoints {
+		fmt.Fprintf(&buf, "uptimer_failures_total%s %d", label(m.id), m.failures)
+		if openMetrics && m.incidents > 0 {
+			fmt.Fprintf(&buf, ` # {incident="%d"} 1 %.3f`, m.incidents, float64(m.lastIncident.UnixMilli())/1000)
+		}
+		buf.WriteByte('\n')
+	}
+	family("uptimer_incidents_total", "counter", "Outages, each starting with the first failed check after a success.")
+	for _, m := range endpoints {
+		fmt.Fprintf(&buf, "uptimer_incidents_total%s %d\n", label(m.id), m.incidents)
+	}
+	family("uptimer_longest_outage_seconds", "gauge", "Longest outage so far, from the first failed check to recovery.")
+	for _, m := range endpoints {
+		fmt.Fprintf(&buf, "uptimer_longest_outage_seconds%s %g\n", label(m.id), m.longestOutage.Seconds())
+	}
+	family("uptimer_response_time_seconds", "gauge", "Duration of the last check.")
+	for _, m := range endpoints {
+		if m.checked {
+			fmt.Fprintf(&buf, "uptimer_response_time_seconds%s %g\n", label(m.id), m.responseTime.Seconds())
+		}
+	}
+	family("uptimer_captured_value", "gauge", "Latest number captured from the body with capture=.")
+	for _, m := range endpoints {
+		if m.captured != nil {
+			fmt.Fprintf(&buf, "uptimer_captured_value%s %g\n", label(m.id), *m.captured)
+		}
+	}
+
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	w.Write(buf.Bytes())
+}
+
+// metricsLabelEscaper escapes a Prometheus label value.
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// apiConfigHandler reports the endpoints as they are loaded now, which may
+// differ from the files on disk until the next reload. Secrets are
+// redacted by EndpointConfig.MarshalJSON.
+func apiConfigHandler(w http.ResponseWriter, r *http.Request) {
+	type endpointConfig struct {
+		ID           string         `json:"id"`
+		URL          string         `json:"url"`
+		Name         string         `json:"name,omitempty"`
+		Severity     string         `json:"severity"`
+		ExpectedCode string         `json:"expected_code"`
+		CodesFile    string         `json:"codes_file,omitempty"`
+		Origin       string         `json:"origin"`
+		Config       EndpointConfig `json:"config"`
+	}
+	response := struct {
+		ConfigFiles []string         `json:"config_files,omitempty"`
+		LoadedAt    time.Time        `json:"loaded_at"`
+		Interval    string           `json:"interval"`
+		Endpoints   []endpointConfig `json:"endpoints"`
+	}{Endpoints: []endpointConfig{}}
+
+	// Held so a reload in progress is not seen half applied.
+	reloadMu.Lock()
+	response.ConfigFiles = config_files
+	response.LoadedAt = config_loaded
+	response.Interval = wait_time.String()
+	for _, stats := range orderedEndpoints() {
+		stats.mu.Lock()
+		response.Endpoints = append(response.Endpoints, endpointConfig{
+			ID:           stats.ID,
+			URL:          stats.URL,
+			Name:         stats.Name,
+			Severity:     stats.Severity,
+			ExpectedCode: stats.ExpectedCode,
+			CodesFile:    stats.CodesFile,
+			Origin:       stats.origin,
+			Config:       stats.Config,
+		})
+		stats.mu.Unlock()
+	}
+	reloadMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func apiVersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readBuildInfo())
+}
+
+// badgeColors are the shields.io colors for each endpoint state.
+var badgeColors = map[string]string{stateUp: "#4c1", stateDown: "#e05d44", stateDegraded: "#dfb317", statePending: "#9f9f9f"}
+
+// badgeHandler serves a shields.io style SVG badge with an endpoint's
+// state, e.g. /badge?url=https://example.com&uptime=true, to embed in a
+// README or wiki page.
+func badgeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	id := cmp.Or(query.Get("id"), query.Get("url"))
 	endpointsMu.RLock()
-	defer endpointsMu.RUnlock()
+	stats, ok := endpoints[id]
+	endpointsMu.RUnlock()
 
-	var statsList []*EndpointStats
-	for _, stats := range endpoints {
-		statsList = append(statsList, stats)
+	label := query.Get("label")
+	message, color := "unknown", badgeColors[statePending]
+	code := http.StatusOK
+	if !ok {
+		label = cmp.Or(label, "status")
+		code = http.StatusNotFound
+	} else {
+		showUptime, _ := strconv.ParseBool(query.Get("uptime"))
+		stats.mu.Lock()
+		label = cmp.Or(label, stats.Name, "status")
+		message, color = stats.State, badgeColors[stats.State]
+		if showUptime && stats.TotalChecks > 0 {
+			message += fmt.Sprintf(" %.2f%%", stats.uptimePercent())
+		}
+		stats.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// Image proxies such as GitHub's would otherwise keep showing an old state.
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	w.WriteHeader(code)
+	writeBadge(w, label, message, color)
+}
+
+// writeBadge draws a flat two-part badge. Text widths are estimated, as
+// the viewer's font is not known.
+func writeBadge(w io.Writer, label, message, color string) {
+	labelWidth := utf8.RuneCountInString(label)*7 + 10
+	messageWidth := utf8.RuneCountInString(message)*7 + 10
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">
+<title>%[3]s: %[4]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[5]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[3]s</text><text x="%[7]d" y="14">%[3]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[8]d" y="14">%[4]s</text>
+</g>
+</svg>
+`, labelWidth+messageWidth, labelWidth, html.EscapeString(label), html.EscapeString(message), messageWidth, color, labelWidth/2, labelWidth+messageWidth/2)
+}
+
+func apiDailyHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		id = r.URL.Query().Get("url")
+	}
+	if id == "" {
+		http.Error(w, "missing id parameter", http.StatusBadRequest)
+		return
+	}
+
+	endpointsMu.RLock()
+	stats, ok := endpoints[id]
+	endpointsMu.RUnlock()
+	if !ok {
+		http.Error(w, "unknown endpoint", http.StatusNotFound)
+		return
+	}
+
+	type dailyUptime struct {
+		DailyStats
+		UptimePercent float64 `json:"uptime_percent"`
 	}
 
+	stats.mu.Lock()
+	days := make([]dailyUptime, 0, len(stats.Daily))
+	for i := len(stats.Daily) - 1; i >= 0; i-- {
+		day := dailyUptime{DailyStats: *stats.Daily[i]}
+		if day.TotalChecks > 0 {
+			day.UptimePercent = float64(day.SuccessfulChecks) / float64(day.TotalChecks) * 100
+		}
+		days = append(days, day)
+	}
+	stats.mu.Unlock()
+
 	response := struct {
-		StartTime string           `json:"start_time"`
-		Uptime    string           `json:"uptime"`
-		Endpoints []*EndpointStats `json:"endpoints"`
+		ID       string        `json:"id"`
+		URL      string        `json:"url"`
+		Timezone string        `json:"timezone"`
+		Days     []dailyUptime `json:"days"`
 	}{
-		StartTime: startTime.Format(time.RFC3339),
-		Uptime:    time.Since(startTime).Round(time.Second).String(),
-		Endpoints: statsList,
+		ID:       stats.ID,
+		URL:      stats.URL,
+		Timezone: location.String(),
+		Days:     days,
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var setupOnce sync.Once
+
+// setup gives the globals main would set from the flags their defaults.
+func setup(t *testing.T) {
+	t.Helper()
+	setupOnce.Do(func() {
+		var err error
+		if alert_template, err = parseMessageTemplate("alert", defaultAlertTemplate); err != nil {
+			t.Fatal(err)
+		}
+		if ok_template, err = parseMessageTemplate("ok", defaultOkTemplate); err != nil {
+			t.Fatal(err)
+		}
+		history_max = 1000
+		daily_days = 30
+		degraded_window = 20
+		cert_checks = make(chan struct{}, 8)
+	})
+}
+
+// statusServer answers every request with the given status code.
+func statusServer(t *testing.T, code int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCodesFileRefreshReachesPorts(t *testing.T) {
+	setup(t)
+	ok := statusServer(t, http.StatusOK)
+	failing := statusServer(t, http.StatusInternalServerError)
+	failingURL, _ := url.Parse(failing.URL)
+
+	codes := filepath.Join(t.TempDir(), "codes.txt")
+	if err := os.WriteFile(codes, []byte("200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats := regex_to_handle(ok.URL+"/ok codes-file="+codes+" ports="+failingURL.Port(), "test")
+	if stats == nil {
+		t.Fatal("endpoint line rejected")
+	}
+	if result := checkEndpoint(stats); result.up {
+		t.Fatalf("check passed with a port returning 500: %s", result.message)
+	}
+
+	if err := os.WriteFile(codes, []byte("200 500\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(codes, later, later); err != nil {
+		t.Fatal(err)
+	}
+	refreshCodesFile(stats)
+	if result := checkEndpoint(stats); !result.up {
+		t.Fatalf("check failed after the codes-file allowed 500: %s", result.message)
+	}
+}
+
+func TestStatusAPIDuringChecks(t *testing.T) {
+	setup(t)
+	srv := statusServer(t, http.StatusOK)
+	stats := regex_to_handle(srv.URL+"/ok budget=1s", "test")
+	register(stats)
+	t.Cleanup(func() {
+		endpointsMu.Lock()
+		delete(endpoints, stats.ID)
+		endpointsMu.Unlock()
+	})
+
+	// Run with -race: encoding must not read what a check is writing.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			checkEndpoint(stats)
+		}
+	}()
+	for range 20 {
+		rec := httptest.NewRecorder()
+		apiStatusHandler(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		io.Copy(io.Discard, rec.Body)
+	}
+	<-done
+}
+
+func TestReloadDuringChecks(t *testing.T) {
+	setup(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+	config := filepath.Join(t.TempDir(), "endpoints.txt")
+	write := func(options string) {
+		if err := os.WriteFile(config, []byte("1\n"+srv.URL+"/ok cert-errors=warn "+options+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("timeout=5s")
+	config_files = []string{config}
+	l := newLoader(true)
+	if err := l.loadFile(config, true); err != nil || len(l.list) != 1 {
+		t.Fatalf("loading %s: %v", config, err)
+	}
+	stats := l.list[0]
+	t.Cleanup(func() {
+		endpointsMu.Lock()
+		delete(endpoints, stats.ID)
+		endpointsMu.Unlock()
+		config_files = nil
+	})
+
+	// Run with -race: a reload replaces the settings of a running check.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m := newMonitor(stats)
+		for range 10 {
+			checkSSLCert(stats.URL, stats)
+			orderedEndpoints()
+			if result := checkEndpoint(stats); !result.up {
+				t.Errorf("check failed: %s", result.message)
+			}
+			// A recovery to alert about, with the endpoint's severity.
+			m.wasUp = false
+			m.step()
+		}
+	}()
+	severities := []string{"info", "critical", "warning"}
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		write(strings.Repeat(" ", i%2) + "timeout=6s sni=127.0.0.1 severity=" + severities[i%3])
+		reloadEndpoints()
+	}
+}
+
+// captureOutput returns what f logs.
+func captureOutput(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	f()
+	os.Stdout = stdout
+	w.Close()
+	return <-out
+}
+
+func TestMaxChecksCompletion(t *testing.T) {
+	setup(t)
+	srv := statusServer(t, http.StatusOK)
+	config := filepath.Join(t.TempDir(), "endpoints.txt")
+	write := func(maxChecks string) {
+		if err := os.WriteFile(config, []byte("1\n"+srv.URL+"/ok max-checks="+maxChecks+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1")
+	config_files = []string{config}
+	l := newLoader(true)
+	if err := l.loadFile(config, true); err != nil || len(l.list) != 1 {
+		t.Fatalf("loading %s: %v", config, err)
+	}
+	stats := l.list[0]
+	t.Cleanup(func() {
+		stats.stop()
+		endpointsMu.Lock()
+		delete(endpoints, stats.ID)
+		endpointsMu.Unlock()
+		config_files = nil
+	})
+
+	out := captureOutput(t, func() { handle_endpoint(newMonitor(stats)) })
+	if !strings.Contains(out, "completed after 1 checks") {
+		t.Errorf("no completion message in:\n%s", out)
+	}
+	if strings.Contains(out, "monitoring stopped") {
+		t.Errorf("completion also logged as a stop:\n%s", out)
+	}
+
+	// Raising the limit resumes the endpoint until it reaches the new one.
+	write("2")
+	reloadEndpoints()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats.mu.Lock()
+		total, completed := stats.TotalChecks, stats.Completed
+		stats.mu.Unlock()
+		if total == 2 && completed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after the reload: %d checks, completed %v", total, completed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTimeoutPhaseCleared(t *testing.T) {
+	setup(t)
+	var slow atomic.Bool
+	slow.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	stats := regex_to_handle(srv.URL+"/ok timeout=100ms", "test")
+	if stats == nil {
+		t.Fatal("endpoint line rejected")
+	}
+	if result := checkEndpoint(stats); result.up || stats.LastTimeoutPhase == "" {
+		t.Fatalf("up %v, timeout phase %q after a timeout", result.up, stats.LastTimeoutPhase)
+	}
+	slow.Store(false)
+	if result := checkEndpoint(stats); !result.up || stats.LastTimeoutPhase != "" {
+		t.Fatalf("up %v, timeout phase %q after a passing check", result.up, stats.LastTimeoutPhase)
+	}
+}
+
+func TestFastPercentCountsBudgetChecks(t *testing.T) {
+	stats := &EndpointStats{}
+	// Checks made before a reload added budget= do not count.
+	stats.TotalChecks = 10
+	recordLatency(stats, 0, time.Second)
+	stats.TotalChecks++
+	recordLatency(stats, 100*time.Millisecond, time.Second)
+	stats.TotalChecks++
+	recordLatency(stats, 100*time.Millisecond, time.Millisecond)
+	if stats.Latency.Checks != 2 || stats.Latency.FastPercent != 50 {
+		t.Fatalf("%d checks, %.2f%% fast; want 2 checks, 50%% fast", stats.Latency.Checks, stats.Latency.FastPercent)
+	}
+}
+
+func TestSummaryKeepsAbsentEndpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	end := time.Now()
+	life := func(checks int64) *lifetimeStats {
+		return &lifetimeStats{Since: end.Add(-90 * 24 * time.Hour), Runs: 2, TotalChecks: checks, SuccessfulChecks: checks}
+	}
+	err := writeSummaryFile(path, summaryReport{
+		EndTime:   end,
+		Endpoints: []endpointSummary{{ID: "listed", Lifetime: life(10)}, {ID: "removed", Lifetime: life(20)}},
+		Absent: []pastEndpoint{
+			{ID: "recent", LastSeen: end.Add(-24 * time.Hour), Lifetime: life(30)},
+			{ID: "expired", LastSeen: end.Add(-60 * 24 * time.Hour), Lifetime: life(40)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := readSummaryFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, retention := previous_summary, summary_retention
+	t.Cleanup(func() { previous_summary, summary_retention = saved, retention })
+	previous_summary, summary_retention = previous, 30*24*time.Hour
+
+	listed := map[string]bool{"listed": true}
+	var ids []string
+	for _, e := range retainedEndpoints(listed, end.Add(time.Hour)) {
+		ids = append(ids, e.ID)
+	}
+	if got := strings.Join(ids, " "); got != "recent removed" {
+		t.Errorf("absent endpoints %q, want \"recent removed\"", got)
+	}
+	if removed := previous["removed"]; !removed.LastSeen.Equal(end) || removed.Lifetime.TotalChecks != 20 {
+		t.Errorf("removed endpoint kept as %+v", removed)
+	}
+	summary_retention = 0
+	if retained := retainedEndpoints(listed, end.Add(time.Hour)); len(retained) != 0 {
+		t.Errorf("-summary-retention 0 kept %+v", retained)
+	}
+}
+
+func TestReloadRetainsRemovedEndpoint(t *testing.T) {
+	setup(t)
+	srv := statusServer(t, http.StatusOK)
+	config := filepath.Join(t.TempDir(), "endpoints.txt")
+	write := func(paths ...string) {
+		text := "1\n"
+		for _, path := range paths {
+			text += srv.URL + path + "\n"
+		}
+		if err := os.WriteFile(config, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("/kept", "/removed")
+	config_files = []string{config}
+	l := newLoader(true)
+	if err := l.loadFile(config, true); err != nil || len(l.list) != 2 {
+		t.Fatalf("loading %s: %v", config, err)
+	}
+	saved, retention, file := previous_summary, summary_retention, summary_file
+	previous_summary, summary_retention, summary_file = nil, time.Hour, filepath.Join(t.TempDir(), "summary.json")
+	t.Cleanup(func() {
+		endpointsMu.Lock()
+		for _, stats := range l.list {
+			delete(endpoints, stats.ID)
+		}
+		endpointsMu.Unlock()
+		config_files = nil
+		previous_summary, summary_retention, summary_file = saved, retention, file
+	})
+	for _, stats := range l.list {
+		checkEndpoint(stats)
+	}
+	removedID := l.list[1].ID
+
+	absent := func() []pastEndpoint {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		apiStatusHandler(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		var status struct {
+			Absent []pastEndpoint `json:"absent"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status.Absent
+	}
+	write("/kept")
+	reloadEndpoints()
+	got := absent()
+	if len(got) != 1 || got[0].ID != removedID || got[0].Lifetime.Runs != 1 || got[0].Lifetime.TotalChecks != 1 {
+		t.Fatalf("/api/status absent = %+v, want %s with this run's check", got, removedID)
+	}
+
+	// Listed again, it carries on from the retained totals.
+	write("/kept", "/removed")
+	reloadEndpoints()
+	if got := absent(); len(got) != 0 {
+		t.Errorf("/api/status absent = %+v after listing it again", got)
+	}
+	endpointsMu.RLock()
+	again := endpoints[removedID]
+	endpointsMu.RUnlock()
+	again.stop()
+	again.mu.Lock()
+	life, checks := lifetimeOf(again), again.TotalChecks
+	again.mu.Unlock()
+	if life.Runs != 1 || life.TotalChecks != 1+checks {
+		t.Errorf("lifetime after listing it again: %d runs, %d checks; want 1 run, %d checks", life.Runs, life.TotalChecks, 1+checks)
+	}
+}
+
+// The samples of RFC 3492 section 7.1, encoded without the optional
+// mixed-case annotation.
+func TestPunycode(t *testing.T) {
+	for _, tt := range []struct{ sample, label, want string }{
+		{"A", "ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"},
+		{"B", "他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+		{"C", "他們爲什麽不說中文", "ihqwctvzc91f659drss3x8bo0yb"},
+		{"D", "Pročprostěnemluvíčesky", "Proprostnemluvesky-uyb24dma41a"},
+		{"E", "למההםפשוטלאמדבריםעברית", "4dbcagdahymbxekheh6e0a7fei0b"},
+		{"F", "यहलोगहिन्दीक्योंनहींबोलसकतेहैं", "i1baa7eci9glrd9b2ae1bj0hfcgg6iyaf8o0a1dig0cd"},
+		{"G", "なぜみんな日本語を話してくれないのか", "n8jok5ay5dzabd5bym9f0cm5685rrjetr6pdxa"},
+		{"H", "세계의모든사람들이한국어를이해한다면얼마나좋을까", "989aomsvi5e83db1d2a355cv1e0vak1dwrv93d5xbh15a0dt30a5jpsd879ccm6fea98c"},
+		{"I", "почемужеонинеговорятпорусски", "b1abfaaepdrnnbgefbadotcwatmq2g4l"},
+		{"J", "PorquénopuedensimplementehablarenEspañol", "PorqunopuedensimplementehablarenEspaol-fmd56a"},
+		{"K", "TạisaohọkhôngthểchỉnóitiếngViệt", "TisaohkhngthchnitingVit-kjcr8268qyxafd2f1b9g"},
+		{"L", "3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+		{"M", "安室奈美恵-with-SUPER-MONKEYS", "-with-SUPER-MONKEYS-pc58ag80a8qai00g7n9n"},
+		{"N", "Hello-Another-Way-それぞれの場所", "Hello-Another-Way--fc4qua05auwb3674vfr0b"},
+		{"O", "ひとつ屋根の下2", "2-u9tlzr9756bt3uc0v"},
+		{"P", "MajiでKoiする5秒前", "MajiKoi5-783gue6qz075azm5e"},
+		{"Q", "パフィーdeルンバ", "de-jg4avhby1noc0d"},
+		{"R", "そのスピードで", "d9juau41awczczp"},
+		{"S", "-> $1.00 <-", "-> $1.00 <--"},
+	} {
+		if got := punycode(tt.label); got != tt.want {
+			t.Errorf("sample %s: punycode(%q) = %q, want %q", tt.sample, tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestPunycodeHost(t *testing.T) {
+	for _, tt := range []struct{ host, want string }{
+		{"Bücher.example", "xn--bcher-kva.example"},
+		{"münchen.DE", "xn--mnchen-3ya.de"},
+		{"EXAMPLE.com", "example.com"},
+	} {
+		if got := punycodeHost(tt.host); got != tt.want {
+			t.Errorf("punycodeHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestReloadKeepsTokenForSameCredentials(t *testing.T) {
+	setup(t)
+	var mu sync.Mutex
+	var clients []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			r.ParseForm()
+			mu.Lock()
+			clients = append(clients, r.PostForm.Get("client_id"))
+			mu.Unlock()
+			io.WriteString(w, `{"access_token":"`+r.PostForm.Get("client_id")+`","expires_in":3600}`)
+			return
+		}
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	config := filepath.Join(t.TempDir(), "endpoints.txt")
+	write := func(clientID string) {
+		line := srv.URL + "/ok token-url=" + srv.URL + "/token client-id=" + clientID + " client-secret=s"
+		if err := os.WriteFile(config, []byte("1\n"+line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a")
+	config_files = []string{config}
+	l := newLoader(true)
+	if err := l.loadFile(config, true); err != nil || len(l.list) != 1 {
+		t.Fatalf("loading %s: %v", config, err)
+	}
+	stats := l.list[0]
+	t.Cleanup(func() {
+		endpointsMu.Lock()
+		delete(endpoints, stats.ID)
+		endpointsMu.Unlock()
+		config_files = nil
+	})
+
+	for _, clientID := range []string{"a", "a", "b"} {
+		write(clientID)
+		reloadEndpoints()
+		if result := checkEndpoint(stats); !result.up {
+			t.Fatalf("check failed: %s", result.message)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(clients, " "); got != "a b" {
+		t.Errorf("tokens fetched for %q, want \"a b\"", got)
+	}
+}
+
+func TestSplitCustomWebhook(t *testing.T) {
+	for _, tt := range []struct{ value, target, path string }{
+		{"https://hooks.example.com/x alert.tmpl", "https://hooks.example.com/x", "alert.tmpl"},
+		{`https://hooks.example.com/x C:\Program Files\uptimer\alert.tmpl`, "https://hooks.example.com/x", `C:\Program Files\uptimer\alert.tmpl`},
+		{" https://hooks.example.com/x \t my alert.tmpl ", "https://hooks.example.com/x", "my alert.tmpl"},
+	} {
+		target, path, ok := splitCustomWebhook(tt.value)
+		if !ok || target != tt.target || path != tt.path {
+			t.Errorf("splitCustomWebhook(%q) = %q, %q, %v; want %q, %q", tt.value, target, path, ok, tt.target, tt.path)
+		}
+	}
+	if _, _, ok := splitCustomWebhook("https://hooks.example.com/x"); ok {
+		t.Error("a value without a template file was accepted")
+	}
+}
+
+func TestPortPanicFailsThePort(t *testing.T) {
+	setup(t)
+	ok := statusServer(t, http.StatusOK)
+	other := statusServer(t, http.StatusOK)
+	otherURL, _ := url.Parse(other.URL)
+	stats := regex_to_handle(ok.URL+"/ok ports="+otherURL.Port(), "test")
+	if stats == nil || len(stats.parts) != 2 {
+		t.Fatal("endpoint line rejected")
+	}
+	// No client to check with: checkEndpoint panics for this port.
+	stats.parts[1].client = nil
+	result := checkEndpoint(stats)
+	if result.up || !strings.Contains(result.reason, "panicked") {
+		t.Fatalf("up %v, reason %q; want the panicking port failed", result.up, result.reason)
+	}
+	if !stats.PortChecks[0].IsUp || stats.PortChecks[1].IsUp {
+		t.Errorf("port checks %+v; want only the second one down", stats.PortChecks)
+	}
+}
+
+func TestRunOnceReportsInConfigOrder(t *testing.T) {
+	setup(t)
+	// The first endpoint answers last.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/a" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	config := filepath.Join(t.TempDir(), "endpoints.txt")
+	if err := os.WriteFile(config, []byte("1\n"+srv.URL+"/a\n"+srv.URL+"/b\n"+srv.URL+"/c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := newLoader(true)
+	if err := l.loadFile(config, true); err != nil || len(l.list) != 3 {
+		t.Fatalf("loading %s: %v", config, err)
+	}
+	t.Cleanup(func() {
+		endpointsMu.Lock()
+		for _, stats := range l.list {
+			delete(endpoints, stats.ID)
+		}
+		endpointsMu.Unlock()
+	})
+
+	var allUp bool
+	out := captureOutput(t, func() { allUp = runOnce() })
+	if !allUp {
+		t.Fatalf("not all endpoints up:\n%s", out)
+	}
+	a, b, c := strings.Index(out, srv.URL+"/a"), strings.Index(out, srv.URL+"/b"), strings.Index(out, srv.URL+"/c")
+	if a < 0 || !(a < b && b < c) {
+		t.Errorf("results not in config order:\n%s", out)
+	}
+}
+
+func TestStateChangesRefuseCrossOrigin(t *testing.T) {
+	setup(t)
+	t.Cleanup(func() { muteAlerts(0) })
+	for _, tt := range []struct {
+		header, value string
+		want          int
+	}{
+		{"", "", http.StatusNoContent},
+		{"Origin", "http://localhost:8080", http.StatusNoContent},
+		{"Referer", "http://localhost:8080/", http.StatusNoContent},
+		{"Origin", "https://evil.example", http.StatusForbidden},
+		{"Origin", "null", http.StatusForbidden},
+		{"Referer", "https://evil.example/page", http.StatusForbidden},
+	} {
+		for _, handler := range []http.HandlerFunc{apiMuteHandler, apiUnmuteHandler} {
+			req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/mute", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("%s %q: status %d, want %d", tt.header, tt.value, rec.Code, tt.want)
+			}
+		}
+	}
+}
+
+// recordingNotifier passes the kinds of the events it gets to a channel.
+type recordingNotifier chan string
+
+func (n recordingNotifier) name() string { return "recorder" }
+
+func (n recordingNotifier) notify(event alertEvent) error {
+	n <- event.Kind
+	return nil
+}
+
+func TestStartupGraceHoldsAllAlerts(t *testing.T) {
+	setup(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "10")
+	}))
+	t.Cleanup(srv.Close)
+	stats := regex_to_handle(srv.URL+"/ok cert-errors=warn cert-change=true capture=([0-9]+) capture-max=5", "test")
+	if stats == nil {
+		t.Fatal("endpoint line rejected")
+	}
+	events := make(recordingNotifier, 8)
+	savedNotifiers, savedGrace := notifiers, startup_grace
+	notifiers, startup_grace = []notifier{events}, time.Hour
+	t.Cleanup(func() { notifiers, startup_grace = savedNotifiers, savedGrace })
+
+	// A changed certificate and a captured value out of range, in grace.
+	stats.CertFingerprint = "old"
+	m := newMonitor(stats)
+	m.step()
+	select {
+	case kind := <-events:
+		t.Fatalf("%s alert sent during the startup grace period", kind)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	startup_grace = 0
+	stats.mu.Lock()
+	stats.CertFingerprint = "old"
+	stats.mu.Unlock()
+	m.certChecked = time.Time{}
+	m.step()
+	select {
+	case kind := <-events:
+		if kind != "cert_changed" {
+			t.Errorf("got a %s alert after the grace period, want cert_changed", kind)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no cert_changed alert after the grace period")
+	}
+}